@@ -0,0 +1,60 @@
+package cmd
+
+import (
+	"fmt"
+	"remdit-server/config"
+
+	"github.com/spf13/cobra"
+)
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Inspect and create configuration files",
+}
+
+var configCheckCmd = &cobra.Command{
+	Use:   "check",
+	Short: "Validate the configuration and exit",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cfg, err := config.Load(configFile)
+		if err != nil {
+			return err
+		}
+		if err := cfg.CheckRuntime(); err != nil {
+			return err
+		}
+		source := config.FileUsed()
+		if source == "" {
+			source = "environment variables"
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "config from %s is valid\n", source)
+		return nil
+	},
+}
+
+var configInitForce bool
+
+var configInitCmd = &cobra.Command{
+	Use:   "init [path]",
+	Short: "Write a commented default config file",
+	Args:  cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		path := config.DefaultConfigFile
+		if len(args) > 0 {
+			path = args[0]
+		} else if configFile != "" {
+			path = configFile
+		}
+		if err := config.WriteDefault(path, configInitForce); err != nil {
+			return err
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "wrote default config to %s\n", path)
+		return nil
+	},
+}
+
+func init() {
+	configInitCmd.Flags().BoolVarP(&configInitForce, "force", "f", false, "overwrite an existing file")
+	configCmd.AddCommand(configCheckCmd, configInitCmd)
+	rootCmd.AddCommand(configCmd)
+}
@@ -0,0 +1,166 @@
+package cmd
+
+import (
+	"fmt"
+	"remdit-server/config"
+	"remdit-server/service/apikey"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/spf13/cobra"
+)
+
+var keysFile string
+
+var keysCmd = &cobra.Command{
+	Use:   "keys",
+	Short: "Manage API keys stored in api_keys_file",
+}
+
+// resolveKeysFile 返回 --file 或配置中的 api_keys_file
+func resolveKeysFile() (string, error) {
+	if keysFile != "" {
+		return keysFile, nil
+	}
+	cfg, err := config.Load(configFile)
+	if err != nil {
+		return "", err
+	}
+	if cfg.APIKeysFile == "" {
+		return "", fmt.Errorf("api_keys_file is not configured")
+	}
+	return cfg.APIKeysFile, nil
+}
+
+var (
+	genName   string
+	genScopes []string
+	genTTL    time.Duration
+	genQuota  apikey.Quota
+)
+
+var keysGenerateCmd = &cobra.Command{
+	Use:   "generate",
+	Short: "Generate a new API key and print it once",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if genName == "" {
+			return fmt.Errorf("--name is required")
+		}
+		scopes := make([]apikey.Scope, 0, len(genScopes))
+		for _, s := range genScopes {
+			scope, err := apikey.ParseScope(s)
+			if err != nil {
+				return err
+			}
+			scopes = append(scopes, scope)
+		}
+		path, err := resolveKeysFile()
+		if err != nil {
+			return err
+		}
+		keys, err := apikey.Load(path)
+		if err != nil {
+			return err
+		}
+		for _, k := range keys {
+			if k.Name == genName && !k.Revoked() {
+				return fmt.Errorf("a key named %q already exists", genName)
+			}
+		}
+		key, token := apikey.Generate(genName, scopes, genQuota, genTTL)
+		if err := apikey.Write(path, append(keys, key)); err != nil {
+			return err
+		}
+		fmt.Fprintf(cmd.ErrOrStderr(), "created key %s (%s) in %s, store it now, it will not be shown again:\n", key.Name, key.ID, path)
+		fmt.Fprintln(cmd.OutOrStdout(), token)
+		return nil
+	},
+}
+
+var keysListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List API keys",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		path, err := resolveKeysFile()
+		if err != nil {
+			return err
+		}
+		keys, err := apikey.Load(path)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 0, 2, ' ', 0)
+		fmt.Fprintln(w, "ID\tNAME\tSCOPES\tCREATED\tEXPIRES\tSTATUS")
+		now := time.Now()
+		for _, k := range keys {
+			scopes := make([]string, len(k.Scopes))
+			for i, s := range k.Scopes {
+				scopes[i] = string(s)
+			}
+			expires := "never"
+			if !k.ExpiresAt.IsZero() {
+				expires = k.ExpiresAt.Format(time.RFC3339)
+			}
+			status := "active"
+			switch {
+			case k.Revoked():
+				status = "revoked"
+			case k.Expired(now):
+				status = "expired"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n",
+				k.ID, k.Name, strings.Join(scopes, ","), k.CreatedAt.Format(time.RFC3339), expires, status)
+		}
+		return w.Flush()
+	},
+}
+
+var keysRevokeCmd = &cobra.Command{
+	Use:   "revoke <id|name>",
+	Short: "Revoke an API key",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		path, err := resolveKeysFile()
+		if err != nil {
+			return err
+		}
+		keys, err := apikey.Load(path)
+		if err != nil {
+			return err
+		}
+		var revoked *apikey.Key
+		for _, k := range keys {
+			if (k.ID == args[0] || k.Name == args[0]) && !k.Revoked() {
+				if revoked != nil {
+					return fmt.Errorf("%q matches more than one key, use the key ID", args[0])
+				}
+				revoked = k
+			}
+		}
+		if revoked == nil {
+			return fmt.Errorf("no active key matches %q", args[0])
+		}
+		revoked.RevokedAt = time.Now()
+		if err := apikey.Write(path, keys); err != nil {
+			return err
+		}
+		fmt.Fprintf(cmd.OutOrStdout(), "revoked key %s (%s)\n", revoked.Name, revoked.ID)
+		return nil
+	},
+}
+
+func init() {
+	keysCmd.PersistentFlags().StringVar(&keysFile, "file", "", "key file to manage (default api_keys_file from config)")
+
+	keysGenerateCmd.Flags().StringVar(&genName, "name", "", "human readable key name, logged instead of the key")
+	keysGenerateCmd.Flags().StringSliceVar(&genScopes, "scope", []string{string(apikey.ScopeCreateSession)}, "scopes granted to the key: session:create, admin, metrics:read")
+	keysGenerateCmd.Flags().DurationVar(&genTTL, "expires-in", 0, "key lifetime, e.g. 720h (default never)")
+	keysGenerateCmd.Flags().IntVar(&genQuota.MaxConcurrentSessions, "max-sessions", 0, "maximum concurrent sessions (0 = unlimited)")
+	keysGenerateCmd.Flags().IntVar(&genQuota.SessionsPerDay, "sessions-per-day", 0, "maximum sessions created per day (0 = unlimited)")
+	keysGenerateCmd.Flags().Int64Var(&genQuota.MaxStorageBytes, "max-storage", 0, "maximum bytes stored across live sessions (0 = unlimited)")
+	keysGenerateCmd.Flags().Int64Var(&genQuota.MaxFileSize, "max-file-size", 0, "maximum size of a single file in bytes (0 = server limit)")
+
+	keysCmd.AddCommand(keysGenerateCmd, keysListCmd, keysRevokeCmd)
+	rootCmd.AddCommand(keysCmd)
+}
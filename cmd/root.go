@@ -11,24 +11,33 @@ import (
 	"github.com/spf13/cobra"
 )
 
+var configFile string
+
 var rootCmd = &cobra.Command{
-	Use: "remdit-server",
+	Use:          "remdit-server",
+	SilenceUsage: true,
 	PreRun: func(cmd *cobra.Command, args []string) {
 		logger := slog.New(slog.NewJSONHandler(os.Stdout, &slog.HandlerOptions{
-			Level: slog.LevelDebug,
+			Level: config.LogLevel,
 		}))
 		slog.SetDefault(logger)
-		config.InitConfig()
+		config.InitConfig(configFile)
 	},
 	Run: func(cmd *cobra.Command, args []string) {
+		config.Watch(cmd.Context())
 		server.Serve(cmd.Context())
 	},
 }
 
+func init() {
+	rootCmd.PersistentFlags().StringVarP(&configFile, "config", "c", "", "path to config file (default ./config.toml if present, otherwise environment variables only)")
+}
+
 func Execute() {
 	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt)
 	defer cancel()
 	if err := rootCmd.ExecuteContext(ctx); err != nil {
 		slog.Error("failed to execute root command", "err", err)
+		os.Exit(1)
 	}
 }
@@ -3,7 +3,9 @@ package config
 import "time"
 
 const (
-	MaxFileSize = 1024 * 1024 * 2 // 2 MB
+	MaxSessionFiles = 32 // 每个会话最多包含的文件数
+
+	MinAPIKeyLength = 16
 	
 	// WebSocket心跳检测配置
 	WSReadTimeout     = 60 * time.Second  // WebSocket读取超时时间
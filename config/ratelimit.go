@@ -0,0 +1,82 @@
+package config
+
+import (
+	"fmt"
+	"slices"
+)
+
+// 限流策略名称
+const (
+	RateLimitDefault       = "default"
+	RateLimitSessionCreate = "session_create"
+	RateLimitSave          = "save"
+	RateLimitUpgrade       = "upgrade"
+	RateLimitUpload        = "upload"
+	// RateLimitAuth 位于 API key 校验之前, 限制猜测 key 的请求, 只能按 IP 限流
+	RateLimitAuth = "auth"
+)
+
+// 限流维度
+const (
+	RateLimitByIP     = "ip"
+	RateLimitByAPIKey = "apikey"
+	RateLimitByRoom   = "room"
+)
+
+var rateLimitKeys = []string{RateLimitByIP, RateLimitByAPIKey, RateLimitByRoom}
+
+type RateLimitPolicy struct {
+	RPM   int    `toml:"rpm" mapstructure:"rpm"`
+	Burst int    `toml:"burst" mapstructure:"burst"`
+	Key   string `toml:"key" mapstructure:"key"`
+}
+
+var defaultRateLimitKeys = map[string]string{
+	RateLimitDefault:       RateLimitByIP,
+	RateLimitSessionCreate: RateLimitByAPIKey,
+	RateLimitSave:          RateLimitByRoom,
+	RateLimitUpgrade:       RateLimitByIP,
+	RateLimitUpload:        RateLimitByRoom,
+	RateLimitAuth:          RateLimitByIP,
+}
+
+// RateLimit 返回名为 name 的策略, 未配置的字段依次回退到 default 策略和 api_rpm
+func (c *Config) RateLimit(name string) RateLimitPolicy {
+	p := c.RateLimits[name]
+	def := c.RateLimits[RateLimitDefault]
+	if p.RPM <= 0 {
+		p.RPM = def.RPM
+	}
+	if p.RPM <= 0 {
+		p.RPM = max(c.APIRPM, 2)
+	}
+	if p.Burst <= 0 {
+		p.Burst = def.Burst
+	}
+	if p.Burst <= 0 {
+		p.Burst = p.RPM
+	}
+	if p.Key == "" {
+		p.Key = defaultRateLimitKeys[name]
+	}
+	if p.Key == "" {
+		p.Key = RateLimitByIP
+	}
+	return p
+}
+
+func (c *Config) validateRateLimits() []error {
+	var errs []error
+	for name, p := range c.RateLimits {
+		if p.RPM < 0 || p.Burst < 0 {
+			errs = append(errs, fmt.Errorf("rate_limits.%s: rpm and burst must not be negative", name))
+		}
+		if p.Key != "" && !slices.Contains(rateLimitKeys, p.Key) {
+			errs = append(errs, fmt.Errorf("rate_limits.%s: key must be one of %v", name, rateLimitKeys))
+		}
+		if name == RateLimitAuth && p.Key != "" && p.Key != RateLimitByIP {
+			errs = append(errs, fmt.Errorf("rate_limits.%s: key must be %q", name, RateLimitByIP))
+		}
+	}
+	return errs
+}
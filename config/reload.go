@@ -0,0 +1,341 @@
+package config
+
+import (
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net"
+	"net/url"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"reflect"
+	"remdit-server/service/events"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/spf13/viper"
+)
+
+var (
+	reloadMu      sync.Mutex
+	changeHooksMu sync.Mutex
+	changeHooks   []func(old, new *Config)
+)
+
+// OnChange 注册配置热重载成功后的回调
+func OnChange(fn func(old, new *Config)) {
+	changeHooksMu.Lock()
+	defer changeHooksMu.Unlock()
+	changeHooks = append(changeHooks, fn)
+}
+
+// unmarshal 解析 viper 中的配置. running 不为 nil 时是热重载, 只在启动时生效的字段沿用 running 的值,
+// 返回的 ignored 是这些字段中被忽略的修改
+func unmarshal(running *Config) (cfg *Config, ignored []string, err error) {
+	cfg = &Config{}
+	if err := viper.Unmarshal(cfg); err != nil {
+		return nil, nil, fmt.Errorf("failed to unmarshal config: %w", err)
+	}
+	if running != nil {
+		ignored = keepRestartOnly(running, cfg)
+	}
+	cfg.normalize()
+	if err := cfg.Validate(); err != nil {
+		return nil, nil, err
+	}
+	return cfg, ignored, nil
+}
+
+// keepRestartOnly 把只在启动时读取的字段恢复为 running 的值, 返回被忽略修改的字段名.
+// 内容存储, 加密密钥和 fiber 的监听地址与请求体上限创建后不再改变, 清理程序等其他部分也必须继续使用同样的值
+func keepRestartOnly(running, cfg *Config) []string {
+	var ignored []string
+	keep := func(name string, changed bool, restore func()) {
+		if changed {
+			ignored = append(ignored, name)
+			restore()
+		}
+	}
+	keep("api_host", cfg.APIHost != running.APIHost, func() { cfg.APIHost = running.APIHost })
+	keep("api_port", cfg.APIPort != running.APIPort, func() { cfg.APIPort = running.APIPort })
+	keep("storage", cfg.Storage != running.Storage, func() { cfg.Storage = running.Storage })
+	keep("s3", cfg.S3 != running.S3, func() { cfg.S3 = running.S3 })
+	keep("uploads_dir", cfg.UploadsDir != running.UploadsDir, func() { cfg.UploadsDir = running.UploadsDir })
+	keep("encryption_key", cfg.EncryptionKey != running.EncryptionKey, func() { cfg.EncryptionKey = running.EncryptionKey })
+	keep("body_limit_mb", cfg.BodyLimitMB != running.BodyLimitMB, func() { cfg.BodyLimitMB = running.BodyLimitMB })
+	return ignored
+}
+
+// normalize 为未设置的派生字段填充默认值
+func (c *Config) normalize() {
+	if len(c.ServerURLs) == 0 {
+		host := c.APIHost
+		if host == "" || host == "0.0.0.0" || host == "::" {
+			host = "localhost"
+		}
+		c.ServerURLs = []string{fmt.Sprintf("http://%s", net.JoinHostPort(host, strconv.Itoa(c.APIPort)))}
+	}
+	for i, u := range c.ServerURLs {
+		c.ServerURLs[i] = strings.TrimRight(strings.TrimSpace(u), "/")
+	}
+}
+
+func (c *Config) Validate() error {
+	var errs []error
+	if c.APIPort <= 0 || c.APIPort > 65535 {
+		errs = append(errs, fmt.Errorf("api_port %d out of range", c.APIPort))
+	}
+	if c.UploadsDir == "" {
+		errs = append(errs, fmt.Errorf("uploads_dir must not be empty"))
+	}
+	switch c.Storage {
+	case StorageLocal, StorageMemory:
+	case StorageS3:
+		if u, err := url.Parse(c.S3.Endpoint); err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("s3.endpoint %q is not a valid http(s) URL", c.S3.Endpoint))
+		}
+		if c.S3.Bucket == "" {
+			errs = append(errs, fmt.Errorf("s3.bucket is required when storage is s3"))
+		}
+		if c.S3.AccessKey == "" || c.S3.SecretKey == "" {
+			errs = append(errs, fmt.Errorf("s3.access_key and s3.secret_key are required when storage is s3"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("storage must be one of %s, %s or %s", StorageLocal, StorageMemory, StorageS3))
+	}
+	if c.EncryptionKey != "" {
+		if key, err := base64.StdEncoding.DecodeString(c.EncryptionKey); err != nil || len(key) != 32 {
+			errs = append(errs, fmt.Errorf("encryption_key must be 32 bytes encoded as base64"))
+		}
+	}
+	for _, raw := range c.ServerURLs {
+		u, err := url.Parse(raw)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("server_urls entry %q is not a valid http(s) URL", raw))
+		}
+	}
+	if c.APIKeyAuth && len(c.APIKeys) == 0 && c.APIKeysFile == "" {
+		errs = append(errs, fmt.Errorf("api_key_auth is enabled but neither api_keys nor api_keys_file is configured"))
+	}
+	for i, key := range c.APIKeys {
+		if len(key) < MinAPIKeyLength {
+			errs = append(errs, fmt.Errorf("api_keys[%d] is too weak, at least %d characters are required", i, MinAPIKeyLength))
+		}
+	}
+	if c.APIRPM < 0 {
+		errs = append(errs, fmt.Errorf("api_rpm must not be negative"))
+	}
+	errs = append(errs, c.validateRateLimits()...)
+	names := make(map[string]bool, len(c.Webhooks))
+	for i, w := range c.Webhooks {
+		if w.Name == "" {
+			errs = append(errs, fmt.Errorf("webhooks[%d]: name is required", i))
+		} else if names[w.Name] {
+			errs = append(errs, fmt.Errorf("webhooks[%d]: duplicate name %q", i, w.Name))
+		}
+		names[w.Name] = true
+		u, err := url.Parse(w.URL)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("webhooks[%d]: url %q is not a valid http(s) URL", i, w.URL))
+		}
+		if w.Secret == "" {
+			errs = append(errs, fmt.Errorf("webhooks[%d]: secret is required to sign payloads", i))
+		}
+		if w.MaxRetries != nil && *w.MaxRetries < 0 {
+			errs = append(errs, fmt.Errorf("webhooks[%d]: max_retries must not be negative", i))
+		}
+		for _, e := range w.Events {
+			if !slices.Contains(events.AllTypes, events.Type(e)) {
+				errs = append(errs, fmt.Errorf("webhooks[%d]: unknown event %q", i, e))
+			}
+		}
+	}
+	if c.SessionTimeoutHours <= 0 {
+		errs = append(errs, fmt.Errorf("session_timeout_hours must be positive"))
+	}
+	if c.SessionMaxLifetimeH <= 0 {
+		errs = append(errs, fmt.Errorf("session_max_lifetime_hours must be positive"))
+	}
+	if c.SessionWarningMin < 0 {
+		errs = append(errs, fmt.Errorf("session_expiry_warning_minutes must not be negative"))
+	}
+	if c.SessionCheckSec <= 0 {
+		errs = append(errs, fmt.Errorf("session_check_interval_seconds must be positive"))
+	}
+	if c.AuditMaxSizeMB < 0 || c.AuditMaxBackups < 0 {
+		errs = append(errs, fmt.Errorf("audit_max_size_mb and audit_max_backups must not be negative"))
+	}
+	if c.JanitorIntervalMin <= 0 {
+		errs = append(errs, fmt.Errorf("janitor_interval_minutes must be positive"))
+	}
+	if c.OrphanMaxAgeMin < 0 || c.DiskBudgetMB < 0 {
+		errs = append(errs, fmt.Errorf("orphan_max_age_minutes and disk_budget_mb must not be negative"))
+	}
+	if c.BinaryFiles != BinaryReadOnly && c.BinaryFiles != BinaryReject {
+		errs = append(errs, fmt.Errorf("binary_files must be %q or %q", BinaryReadOnly, BinaryReject))
+	}
+	if c.MaxFileSizeMB <= 0 || c.LargeFileKB <= 0 {
+		errs = append(errs, fmt.Errorf("max_file_size_mb and large_file_threshold_kb must be positive"))
+	}
+	if c.UploadExpiryMin <= 0 || c.MaxPendingUploads <= 0 {
+		errs = append(errs, fmt.Errorf("upload_expiry_minutes and max_pending_uploads must be positive"))
+	}
+	// 未完成上传的分块也在 uploads_dir 中, 过期时间不小于 orphan_max_age_minutes 时会被清理程序先删除
+	if c.Storage == StorageLocal && c.UploadExpiryMin >= c.OrphanMaxAgeMin {
+		errs = append(errs, fmt.Errorf("upload_expiry_minutes must be below orphan_max_age_minutes"))
+	}
+	if c.BodyLimitMB < c.MaxFileSizeMB {
+		errs = append(errs, fmt.Errorf("body_limit_mb must be at least max_file_size_mb"))
+	}
+	if _, err := parseLogLevel(c.LogLevel); err != nil {
+		errs = append(errs, err)
+	}
+	return errors.Join(errs...)
+}
+
+func parseLogLevel(s string) (slog.Level, error) {
+	var level slog.Level
+	if err := level.UnmarshalText([]byte(s)); err != nil {
+		return level, fmt.Errorf("invalid log_level %q", s)
+	}
+	return level, nil
+}
+
+func apply(cfg *Config) {
+	if level, err := parseLogLevel(cfg.LogLevel); err == nil {
+		LogLevel.Set(level)
+	}
+	current.Store(cfg)
+}
+
+// Reload 重新读取配置文件, 校验失败时保留当前配置. 只在启动时生效的字段保留运行中的值, 见 keepRestartOnly.
+// viper 不是并发安全的, 读取文件和解析都在 reloadMu 内进行
+func Reload() error {
+	reloadMu.Lock()
+	defer reloadMu.Unlock()
+	if usedFile != "" {
+		if err := viper.ReadInConfig(); err != nil {
+			slog.Error("Failed to read config file, keeping previous config", "err", err)
+			return err
+		}
+	}
+	old := current.Load()
+	cfg, ignored, err := unmarshal(old)
+	if err != nil {
+		slog.Error("Rejected config reload, keeping previous config", "err", err)
+		return err
+	}
+	if len(ignored) > 0 {
+		slog.Warn("Some settings only take effect after a restart, keeping their running values", "fields", ignored)
+	}
+	changed := diff(old, cfg)
+	if len(changed) == 0 {
+		slog.Info("Config reloaded, nothing changed")
+		return nil
+	}
+	apply(cfg)
+	slog.Info("Config reloaded", "changed", changed)
+
+	changeHooksMu.Lock()
+	hooks := append([]func(old, new *Config){}, changeHooks...)
+	changeHooksMu.Unlock()
+	for _, hook := range hooks {
+		hook(old, cfg)
+	}
+	return nil
+}
+
+// Watch 监听配置文件变更和 SIGHUP 信号, 直到 ctx 结束.
+// 不使用 viper.WatchConfig: 它在自己的 goroutine 中读取配置, 无法与 Reload 互斥
+func Watch(ctx context.Context) {
+	if usedFile != "" {
+		if err := watchFile(ctx, usedFile); err != nil {
+			slog.Error("Failed to watch config file", "file", usedFile, "err", err)
+		}
+	}
+
+	sighup := make(chan os.Signal, 1)
+	signal.Notify(sighup, syscall.SIGHUP)
+	go func() {
+		defer signal.Stop(sighup)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-sighup:
+				slog.Info("Received SIGHUP, reloading config")
+				Reload()
+			}
+		}
+	}()
+}
+
+// watchFile 监听配置文件所在的目录, 这样编辑器替换文件和 Kubernetes ConfigMap 切换符号链接也能被发现
+func watchFile(ctx context.Context, file string) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	file = filepath.Clean(file)
+	if err := watcher.Add(filepath.Dir(file)); err != nil {
+		watcher.Close()
+		return err
+	}
+	realFile, _ := filepath.EvalSymlinks(file)
+	go func() {
+		defer watcher.Close()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case e, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				current, _ := filepath.EvalSymlinks(file)
+				changed := filepath.Clean(e.Name) == file && e.Op&(fsnotify.Write|fsnotify.Create) != 0
+				if !changed && (current == "" || current == realFile) {
+					continue
+				}
+				realFile = current
+				slog.Info("Config file changed", "file", e.Name, "op", e.Op.String())
+				Reload()
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				slog.Warn("Config file watcher error", "err", err)
+			}
+		}
+	}()
+	return nil
+}
+
+// diff 返回发生变化的配置项, 敏感字段只记录名称
+func diff(old, new *Config) []string {
+	if old == nil {
+		return nil
+	}
+	var changed []string
+	ov, nv := reflect.ValueOf(*old), reflect.ValueOf(*new)
+	t := ov.Type()
+	for i := range t.NumField() {
+		if reflect.DeepEqual(ov.Field(i).Interface(), nv.Field(i).Interface()) {
+			continue
+		}
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("mapstructure"), ",")
+		if name == "api_keys" || name == "webhooks" || name == "s3" || name == "encryption_key" {
+			changed = append(changed, name)
+			continue
+		}
+		changed = append(changed, fmt.Sprintf("%s: %v -> %v", name, ov.Field(i).Interface(), nv.Field(i).Interface()))
+	}
+	return changed
+}
@@ -0,0 +1,163 @@
+package config
+
+import (
+	"fmt"
+	"os"
+)
+
+// DefaultTemplate 是 `config init` 写出的带注释的默认配置
+const DefaultTemplate = `# remdit-server configuration
+# Every field can also be set through an environment variable of the same
+# name in upper case, e.g. API_PORT=9000 or SERVER_URLS=https://a.example,https://b.example
+
+# Address and port the HTTP server listens on
+api_host = "0.0.0.0"
+api_port = 8080
+
+# Requests per minute allowed per client IP on /api, used by rate limit
+# policies that do not set their own rpm
+api_rpm = 39
+
+# Directory where uploaded files are stored while a session is alive
+uploads_dir = "uploads"
+
+# Where session content lives: "local" (uploads_dir), "memory" (nothing
+# touches disk, lost on restart) or "s3" (any S3-compatible object store).
+# Changing the backend requires a restart.
+storage = "local"
+
+# Master key (32 bytes, base64) used to wrap a random per-session data key.
+# When set, session content is encrypted at rest with AES-256-GCM and the
+# data key is destroyed when the session ends. Generate one with:
+#   openssl rand -base64 32
+encryption_key = ""
+
+# [s3]
+# endpoint = "http://127.0.0.1:9000"
+# region = "us-east-1"
+# bucket = "remdit"
+# prefix = "sessions"
+# access_key = "minioadmin"
+# secret_key = "minioadmin"
+# path_style = true
+
+# Public base URLs used to build edit links; one is picked at random per session.
+# Defaults to http://localhost:<api_port> when empty.
+server_urls = []
+
+# Require an X-API-Key header when creating sessions
+api_key_auth = false
+# Keys managed with "remdit-server keys generate/list/revoke", stored as salted hashes
+api_keys_file = "api_keys.json"
+# Legacy plaintext keys with every scope; must be at least 16 characters long
+api_keys = []
+
+# Sessions without any browser attached are removed after this many idle hours
+session_timeout_hours = 6
+# Hard limit on how long a session may live, even with browsers attached.
+# A "ttl" requested at creation or through the extend endpoint is capped to it.
+session_max_lifetime_hours = 24
+# Rooms and the CLI are warned this many minutes before a session expires
+session_expiry_warning_minutes = 5
+# How often idle and expired sessions are looked for
+session_check_interval_seconds = 60
+
+# The janitor runs at startup and every janitor_interval_minutes when storage
+# is "local". It removes directories in uploads_dir that belong to no live
+# session (e.g. left behind by a crash) once they are older than
+# orphan_max_age_minutes, and when disk_budget_mb is set (0 = unlimited)
+# evicts the longest idle sessions without browsers until usage fits.
+janitor_interval_minutes = 10
+orphan_max_age_minutes = 60
+disk_budget_mb = 0
+
+# What to do with uploads that look like binary data (images, archives, ...):
+# "readonly" accepts them in a read-only mode where images are served as raw
+# bytes and other files as a hex view, "reject" refuses them.
+binary_files = "readonly"
+
+# Largest file accepted in a session and largest request body. body_limit_mb
+# must leave room for every file of a multi-file upload and only takes effect
+# after a restart.
+max_file_size_mb = 2
+body_limit_mb = 10
+# Files larger than this open in large-file mode: GET /api/file returns one
+# page of lines at a time and browsers save changed line ranges as patches.
+large_file_threshold_kb = 512
+
+# Resumable uploads (POST /api/uploads, tus 1.0 compatible) are dropped
+# together with their chunks when no chunk arrives for this many minutes.
+# With storage = "local" it must be below orphan_max_age_minutes so the
+# janitor never removes chunks of an upload that is still in progress.
+upload_expiry_minutes = 30
+# Unfinished resumable uploads allowed at once per API key, or per client IP
+# when the request carries no key. Each one reserves up to max_file_size_mb.
+max_pending_uploads = 4
+
+# One of debug, info, warn, error
+log_level = "debug"
+
+# Append-only JSONL audit log of session creation, room joins, saves and
+# teardown. Rotated when it grows beyond audit_max_size_mb, keeping
+# audit_max_backups old files (0 keeps all). Set audit_log = "" to disable.
+audit_log = "audit/audit.jsonl"
+audit_max_size_mb = 50
+audit_max_backups = 10
+
+# Token bucket rate limit policies. rpm is the refill rate per minute, burst
+# the bucket size and key what requests are grouped by: ip, apikey or room.
+# Unset fields fall back to the default policy and then to api_rpm.
+# [rate_limits.default]
+# rpm = 39
+# key = "ip"
+# [rate_limits.session_create]
+# rpm = 10
+# burst = 3
+# key = "apikey"
+# [rate_limits.save]
+# rpm = 30
+# key = "room"
+# [rate_limits.upgrade]
+# rpm = 60
+# key = "ip"
+# [rate_limits.upload]
+# rpm = 120
+# key = "room"
+# auth runs before the API key check on authenticated routes, so invalid
+# keys cannot be guessed without limit. Its key is always "ip".
+# [rate_limits.auth]
+# rpm = 60
+# key = "ip"
+
+# Webhooks receive a signed JSON POST for every matching event. The
+# X-Remdit-Signature header is "sha256=" + hex HMAC-SHA256 of
+# "<X-Remdit-Timestamp>.<body>" keyed with secret. Leave events empty to
+# receive everything: session.created, file.added, cli.connected,
+# cli.disconnected, participant.joined, participant.left, save.requested,
+# save.succeeded, save.failed, session.finished, session.cleaned_up
+# [[webhooks]]
+# name = "chat"
+# url = "https://chat.example.com/hooks/remdit"
+# secret = "change-me"
+# events = ["save.succeeded", "save.failed"]
+# Failed deliveries are retried with backoff, 0 disables retries.
+# max_retries = 5
+`
+
+// WriteDefault 将默认配置写入 path, force 为 false 时不会覆盖已有文件
+func WriteDefault(path string, force bool) error {
+	flag := os.O_WRONLY | os.O_CREATE | os.O_EXCL
+	if force {
+		flag = os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	}
+	f, err := os.OpenFile(path, flag, 0600)
+	if err != nil {
+		if os.IsExist(err) {
+			return fmt.Errorf("%s already exists, use --force to overwrite", path)
+		}
+		return err
+	}
+	defer f.Close()
+	_, err = f.WriteString(DefaultTemplate)
+	return err
+}
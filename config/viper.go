@@ -1,9 +1,11 @@
 package config
 
 import (
+	"fmt"
 	"log/slog"
 	"os"
 	"strings"
+	"sync/atomic"
 
 	"github.com/spf13/viper"
 )
@@ -13,31 +15,192 @@ type Config struct {
 	APIPort             int      `toml:"api_port" mapstructure:"api_port"`
 	APIRPM              int      `toml:"api_rpm" mapstructure:"api_rpm"`
 	UploadsDir          string   `toml:"uploads_dir" mapstructure:"uploads_dir"`
+	Storage             string   `toml:"storage" mapstructure:"storage"`
+	S3                  S3Config `toml:"s3" mapstructure:"s3"`
+	EncryptionKey       string   `toml:"encryption_key" mapstructure:"encryption_key"`
 	ServerURLs          []string `toml:"server_urls" mapstructure:"server_urls"`
 	APIKeyAuth          bool     `toml:"api_key_auth" mapstructure:"api_key_auth"`
 	APIKeys             []string `toml:"api_keys" mapstructure:"api_keys"`
+	APIKeysFile         string   `toml:"api_keys_file" mapstructure:"api_keys_file"`
 	SessionTimeoutHours int      `toml:"session_timeout_hours" mapstructure:"session_timeout_hours"`
+	SessionMaxLifetimeH int      `toml:"session_max_lifetime_hours" mapstructure:"session_max_lifetime_hours"`
+	SessionWarningMin   int      `toml:"session_expiry_warning_minutes" mapstructure:"session_expiry_warning_minutes"`
+	SessionCheckSec     int      `toml:"session_check_interval_seconds" mapstructure:"session_check_interval_seconds"`
+	LogLevel            string   `toml:"log_level" mapstructure:"log_level"`
+	AuditLog            string   `toml:"audit_log" mapstructure:"audit_log"`
+	AuditMaxSizeMB      int      `toml:"audit_max_size_mb" mapstructure:"audit_max_size_mb"`
+	AuditMaxBackups     int      `toml:"audit_max_backups" mapstructure:"audit_max_backups"`
+	JanitorIntervalMin  int      `toml:"janitor_interval_minutes" mapstructure:"janitor_interval_minutes"`
+	OrphanMaxAgeMin     int      `toml:"orphan_max_age_minutes" mapstructure:"orphan_max_age_minutes"`
+	DiskBudgetMB        int      `toml:"disk_budget_mb" mapstructure:"disk_budget_mb"`
+	BinaryFiles         string   `toml:"binary_files" mapstructure:"binary_files"`
+	MaxFileSizeMB       int      `toml:"max_file_size_mb" mapstructure:"max_file_size_mb"`
+	BodyLimitMB         int      `toml:"body_limit_mb" mapstructure:"body_limit_mb"`
+	LargeFileKB         int      `toml:"large_file_threshold_kb" mapstructure:"large_file_threshold_kb"`
+	UploadExpiryMin     int      `toml:"upload_expiry_minutes" mapstructure:"upload_expiry_minutes"`
+	MaxPendingUploads   int      `toml:"max_pending_uploads" mapstructure:"max_pending_uploads"`
+
+	RateLimits map[string]RateLimitPolicy `toml:"rate_limits" mapstructure:"rate_limits"`
+	Webhooks   []Webhook                  `toml:"webhooks" mapstructure:"webhooks"`
 }
 
-var C *Config
+const (
+	StorageLocal  = "local"
+	StorageMemory = "memory"
+	StorageS3     = "s3"
+)
 
-func InitConfig() {
-	if C != nil {
-		return
-	}
-	viper.SetConfigFile("config.toml")
+type S3Config struct {
+	Endpoint  string `toml:"endpoint" mapstructure:"endpoint"`
+	Region    string `toml:"region" mapstructure:"region"`
+	Bucket    string `toml:"bucket" mapstructure:"bucket"`
+	Prefix    string `toml:"prefix" mapstructure:"prefix"`
+	AccessKey string `toml:"access_key" mapstructure:"access_key"`
+	SecretKey string `toml:"secret_key" mapstructure:"secret_key"`
+	PathStyle bool   `toml:"path_style" mapstructure:"path_style"`
+}
+
+type Webhook struct {
+	Name       string   `toml:"name" mapstructure:"name"`
+	URL        string   `toml:"url" mapstructure:"url"`
+	Secret     string   `toml:"secret" mapstructure:"secret"`
+	Events     []string `toml:"events" mapstructure:"events"`           // 为空时接收所有事件
+	MaxRetries *int     `toml:"max_retries" mapstructure:"max_retries"` // 未设置时重试 5 次, 0 表示不重试
+}
+
+// 上传二进制文件时的处理方式
+const (
+	BinaryReadOnly = "readonly"
+	BinaryReject   = "reject"
+)
+
+const DefaultConfigFile = "config.toml"
+
+var current atomic.Pointer[Config]
+
+// C 返回当前生效的配置, 热重载后会返回新的配置
+func C() *Config {
+	return current.Load()
+}
+
+// MaxFileSize 返回单个文件的大小上限, 单位为字节
+func (c *Config) MaxFileSize() int64 {
+	return int64(c.MaxFileSizeMB) << 20
+}
+
+// BodyLimit 返回请求体的大小上限, 单位为字节
+func (c *Config) BodyLimit() int {
+	return c.BodyLimitMB << 20
+}
+
+// LargeFileSize 返回进入大文件模式的大小, 超过它的文件默认分页加载
+func (c *Config) LargeFileSize() int64 {
+	return int64(c.LargeFileKB) << 10
+}
+
+// LogLevel 是全局日志级别, 随配置热重载更新
+var LogLevel = new(slog.LevelVar)
+
+// 配置文件路径, 为空表示仅使用环境变量
+var usedFile string
+
+// FileUsed 返回当前加载的配置文件路径, 仅使用环境变量时为空
+func FileUsed() string {
+	return usedFile
+}
+
+func setDefaults() {
 	viper.AutomaticEnv()
 	viper.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+	viper.SetDefault("api_host", "0.0.0.0")
+	viper.SetDefault("api_port", 8080)
 	viper.SetDefault("api_rpm", 39)
+	viper.SetDefault("uploads_dir", "uploads")
+	viper.SetDefault("storage", StorageLocal)
+	viper.SetDefault("s3.endpoint", "")
+	viper.SetDefault("s3.region", "us-east-1")
+	viper.SetDefault("s3.bucket", "")
+	viper.SetDefault("s3.prefix", "")
+	viper.SetDefault("s3.access_key", "")
+	viper.SetDefault("s3.secret_key", "")
+	viper.SetDefault("s3.path_style", true)
+	viper.SetDefault("encryption_key", "")
+	viper.SetDefault("server_urls", []string{})
+	viper.SetDefault("api_key_auth", false)
+	viper.SetDefault("api_keys", []string{})
+	viper.SetDefault("api_keys_file", "api_keys.json")
 	viper.SetDefault("session_timeout_hours", 6)
+	viper.SetDefault("session_max_lifetime_hours", 24)
+	viper.SetDefault("session_expiry_warning_minutes", 5)
+	viper.SetDefault("session_check_interval_seconds", 60)
+	viper.SetDefault("log_level", "debug")
+	viper.SetDefault("audit_log", "audit/audit.jsonl")
+	viper.SetDefault("audit_max_size_mb", 50)
+	viper.SetDefault("audit_max_backups", 10)
+	viper.SetDefault("janitor_interval_minutes", 10)
+	viper.SetDefault("orphan_max_age_minutes", 60)
+	viper.SetDefault("disk_budget_mb", 0)
+	viper.SetDefault("binary_files", BinaryReadOnly)
+	viper.SetDefault("max_file_size_mb", 2)
+	viper.SetDefault("body_limit_mb", 10)
+	viper.SetDefault("large_file_threshold_kb", 512)
+	viper.SetDefault("upload_expiry_minutes", 30)
+	viper.SetDefault("max_pending_uploads", 4)
+}
+
+// Load 读取配置, path 为空时优先使用工作目录下的 config.toml, 不存在则只读取环境变量
+func Load(path string) (*Config, error) {
+	setDefaults()
+	file := path
+	if file == "" {
+		if _, err := os.Stat(DefaultConfigFile); err == nil {
+			file = DefaultConfigFile
+		}
+	}
+	if file != "" {
+		viper.SetConfigFile(file)
+		if err := viper.ReadInConfig(); err != nil {
+			return nil, fmt.Errorf("failed to read config file %s: %w", file, err)
+		}
+	}
+	usedFile = file
+	cfg, _, err := unmarshal(nil)
+	return cfg, err
+}
 
-	if err := viper.ReadInConfig(); err != nil {
-		slog.Error("failed to read config file", "err", err)
+func InitConfig(path string) {
+	if current.Load() != nil {
+		return
+	}
+	cfg, err := Load(path)
+	if err != nil {
+		slog.Error("invalid config", "err", err)
 		os.Exit(1)
 	}
-	C = &Config{}
-	if err := viper.Unmarshal(C); err != nil {
-		slog.Error("failed to unmarshal config", "err", err)
+	if err := cfg.CheckRuntime(); err != nil {
+		slog.Error("invalid config", "err", err)
 		os.Exit(1)
 	}
+	if usedFile == "" {
+		slog.Info("No config file found, using defaults and environment variables")
+	} else {
+		slog.Info("Loaded config file", "file", usedFile)
+	}
+	apply(cfg)
+}
+
+// CheckRuntime 检查配置在当前环境下是否可用, 例如上传目录是否可写
+func (c *Config) CheckRuntime() error {
+	if c.Storage != StorageLocal {
+		return nil
+	}
+	if err := os.MkdirAll(c.UploadsDir, 0755); err != nil {
+		return fmt.Errorf("uploads_dir %s is not usable: %w", c.UploadsDir, err)
+	}
+	f, err := os.CreateTemp(c.UploadsDir, ".check-*")
+	if err != nil {
+		return fmt.Errorf("uploads_dir %s is not writable: %w", c.UploadsDir, err)
+	}
+	f.Close()
+	return os.Remove(f.Name())
 }
@@ -0,0 +1,110 @@
+package server
+
+import (
+	"context"
+	"log/slog"
+	"remdit-server/service/audit"
+	"remdit-server/service/quota"
+	"remdit-server/service/stors/filestor"
+	"remdit-server/service/webhook"
+	"sort"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func handleAdminUsage(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"usage": quota.Default().Snapshot()})
+}
+
+func handleAdminWebhookDeliveries(c *fiber.Ctx) error {
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"deliveries": webhook.Default().Deliveries()})
+}
+
+// sessionInfo 描述主文件 f 所在的会话, Size 是会话中所有文件的总大小, 与配额的统计一致
+func sessionInfo(f filestor.File, hub *EditingHub) SessionInfo {
+	files := filestor.ListSession(context.Background(), f.SessionID())
+	var size int64
+	for _, file := range files {
+		size += file.Size()
+	}
+	info := SessionInfo{
+		ID:           f.ID(),
+		Filename:     f.Name(),
+		Size:         size,
+		CreatedAt:    f.CreatedAt(),
+		LastActiveAt: f.CreatedAt(),
+		Origin:       f.Origin(),
+		E2E:          f.E2E(),
+		ExpiresAt:    f.ExpiresAt(),
+		Files:        len(files),
+	}
+	if hub != nil {
+		info.LastActiveAt = hub.LastActiveAt()
+		info.Browsers = hub.ClientCount()
+		info.CLIConnected = hub.sessionConn != nil
+	}
+	return info
+}
+
+func handleAdminListSessions(c *fiber.Ctx) error {
+	hubs := hubManager.Hubs()
+	files := filestor.List(c.Context())
+	sessions := make([]SessionInfo, 0, len(files))
+	for _, f := range files {
+		if f.ID() != f.SessionID() {
+			continue
+		}
+		sessions = append(sessions, sessionInfo(f, hubs[f.ID()]))
+	}
+	sort.Slice(sessions, func(i, j int) bool { return sessions[i].CreatedAt.Before(sessions[j].CreatedAt) })
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"sessions": sessions})
+}
+
+func handleAdminGetSession(c *fiber.Ctx) error {
+	id := c.Params("sessionid")
+	f := filestor.Get(c.Context(), id)
+	if f == nil || f.SessionID() != id {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "session not found"})
+	}
+	hub := hubManager.GetHub(id)
+	detail := SessionDetail{SessionInfo: sessionInfo(f, hub), Participants: []string{}}
+	if hub != nil {
+		detail.Participants = hub.ClientAddrs()
+	}
+	return c.Status(fiber.StatusOK).JSON(detail)
+}
+
+func handleAdminCloseSession(c *fiber.Ctx) error {
+	id := c.Params("sessionid")
+	if f := filestor.Get(c.Context(), id); f == nil || f.SessionID() != id {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "session not found"})
+	}
+	slog.Info("Session force-closed by admin", "sessionid", id, "key", apiKeyFromCtx(c).Name)
+	hubManager.CloseSession(id, "This session was closed by an administrator", "closed by admin")
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "session closed"})
+}
+
+func handleAdminSessionAudit(c *fiber.Ctx) error {
+	entries, err := audit.Query(c.Params("sessionid"))
+	if err != nil {
+		slog.Error("Failed to query audit log", "sessionid", c.Params("sessionid"), "err", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"entries": entries})
+}
+
+func handleAdminBroadcast(c *fiber.Ctx) error {
+	var req BroadcastRequest
+	if err := c.BodyParser(&req); err != nil || req.Message == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "message is required"})
+	}
+	if req.Level == "" {
+		req.Level = "info"
+	}
+	hubs := hubManager.Hubs()
+	for _, hub := range hubs {
+		hub.BroadcastNotice(req.Level, req.Message)
+	}
+	slog.Info("Broadcast maintenance notice", "key", apiKeyFromCtx(c).Name, "sessions", len(hubs))
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "notice sent", "sessions": len(hubs)})
+}
@@ -2,21 +2,23 @@ package server
 
 import (
 	"context"
-	"crypto/sha256"
-	"crypto/subtle"
 	"fmt"
 	"log/slog"
 	"net/http"
 	"os"
 	"remdit-server/config"
+	"remdit-server/service/apikey"
+	"remdit-server/service/crypt"
+	"remdit-server/service/events"
+	"remdit-server/service/resumable"
+	"remdit-server/service/stors/blobstor"
+	"remdit-server/service/webhook"
 	"remdit-server/webembed"
 	"time"
 
 	"github.com/bytedance/sonic"
 	"github.com/gofiber/fiber/v2"
 	"github.com/gofiber/fiber/v2/middleware/filesystem"
-	"github.com/gofiber/fiber/v2/middleware/keyauth"
-	"github.com/gofiber/fiber/v2/middleware/limiter"
 
 	"github.com/gofiber/contrib/websocket"
 	"github.com/gofiber/fiber/v2/middleware/logger"
@@ -27,6 +29,14 @@ var (
 )
 
 func Serve(ctx context.Context) {
+	if err := blobstor.Init(config.C()); err != nil {
+		slog.Error("Failed to initialize content store", "storage", config.C().Storage, "err", err)
+		os.Exit(1)
+	}
+	if err := crypt.Init(config.C()); err != nil {
+		slog.Error("Failed to initialize encryption at rest", "err", err)
+		os.Exit(1)
+	}
 	app := fiber.New(fiber.Config{
 		JSONEncoder:             sonic.Marshal,
 		JSONDecoder:             sonic.Unmarshal,
@@ -36,48 +46,68 @@ func Serve(ctx context.Context) {
 			"127.0.0.1",
 		},
 		ProxyHeader: fiber.HeaderXForwardedFor,
-		BodyLimit:   10 * 1024 * 1024,
+		BodyLimit:   config.C().BodyLimit(),
 	})
 	loggerCfg := logger.ConfigDefault
 	loggerCfg.Format = "${time} | ${status} | ${latency} | ${ip} | ${method} | ${path} | ${queryParams} | ${error}\n"
 	app.Use(logger.New(loggerCfg))
 	rg := app.Group("/api")
-	rg.Use(limiter.New(limiter.Config{
-		Max: max(config.C.APIRPM, 2),
-	}))
-	if config.C.APIKeyAuth && len(config.C.APIKeys) > 0 {
-		rg.Use(keyauth.New(keyauth.Config{
-			Next: func(c *fiber.Ctx) bool {
-				return c.Path() != "/api/session"
-			},
-			KeyLookup: "header:X-API-Key",
-			Validator: func(c *fiber.Ctx, s string) (bool, error) {
-				hashedKey := sha256.Sum256([]byte(s))
-				for _, key := range config.C.APIKeys {
-					hashedApiKey := sha256.Sum256([]byte(key))
-					if subtle.ConstantTimeCompare(hashedKey[:], hashedApiKey[:]) == 1 {
-						return true, nil
-					}
-				}
-				return false, keyauth.ErrMissingOrMalformedAPIKey
-			},
-		}))
-	}
-	rg.Post("/session", handleCreateSession)
-	rg.Get("/session/:sessionid", handleSessionWSUpgrade)
+	go limitStore.StartSweeper(ctx, time.Minute)
+	webhook.Default().Start(ctx)
+	uploadsJanitor.Start(ctx)
+	go hubManager.startIntervalCleanup(ctx)
+	go resumable.Default().StartSweeper(ctx, time.Minute)
+	rg.Post("/session",
+		rateLimit(config.RateLimitAuth),
+		requireKey(apikey.ScopeCreateSession, skipWithoutKeyAuth),
+		rateLimit(config.RateLimitSessionCreate),
+		handleCreateSession,
+	)
+	rg.Get("/session/:sessionid", rateLimit(config.RateLimitUpgrade), handleSessionWSUpgrade)
 	rg.Get("/session/:sessionid", websocket.New(handleSessionWSConn))
-	rg.Get("/socket/:room", handleRoomWSUpgrade)
+	rg.Post("/session/:sessionid/extend", rateLimit(config.RateLimitDefault), handleExtendSession)
+	rg.Get("/session/:sessionid/files", rateLimit(config.RateLimitDefault), handleListSessionFiles)
+	rg.Get("/session/:sessionid/tree", rateLimit(config.RateLimitDefault), handleListDir)
+	rg.Post("/session/:sessionid/open", rateLimit(config.RateLimitDefault), handleOpenFile)
+	rg.Get("/socket/:room", rateLimit(config.RateLimitUpgrade), handleRoomWSUpgrade)
 	rg.Get("/socket/:room", websocket.New(handleRoomWSConn))
 	rg.Use("/file/:fileid", handleFileMiddleware)
-	rg.Put("/file/:fileid", handlePutFile)
-	rg.Get("/file/:fileid", handleGetFile)
+	rg.Put("/file/:fileid", rateLimit(config.RateLimitSave), handlePutFile)
+	rg.Post("/file/:fileid/finish", rateLimit(config.RateLimitSave), handleFinishSession)
+	rg.Get("/file/:fileid", rateLimit(config.RateLimitDefault), handleGetFile)
+	rg.Get("/file/:fileid/raw", rateLimit(config.RateLimitDefault), handleGetRawFile)
+	rg.Get("/file/:fileid/hex", rateLimit(config.RateLimitDefault), handleGetHexFile)
+
+	uploadAuth := rateLimit(config.RateLimitAuth)
+	uploadKey := requireKey(apikey.ScopeCreateSession, skipWithoutKeyAuth)
+	uploads := rg.Group("/uploads", tusMiddleware)
+	uploads.Options("", handleUploadOptions)
+	uploads.Post("", uploadAuth, uploadKey, rateLimit(config.RateLimitSessionCreate), handleCreateUpload)
+	uploads.Head("/:uploadid", uploadAuth, uploadKey, rateLimit(config.RateLimitUpload), handleUploadStatus)
+	uploads.Patch("/:uploadid", uploadAuth, uploadKey, rateLimit(config.RateLimitUpload), handlePatchUpload)
+	uploads.Delete("/:uploadid", uploadAuth, uploadKey, rateLimit(config.RateLimitUpload), handleDeleteUpload)
+
+	// 只读的用量和运行状态接口也对 metrics:read 开放, 其余管理接口要求 admin
+	adminKey := requireKey(apikey.ScopeAdmin, nil)
+	metricsKey := requireKey(apikey.ScopeReadMetrics, nil)
+	adminLimit := rateLimit(config.RateLimitDefault)
+	admin := rg.Group("/admin", rateLimit(config.RateLimitAuth))
+	admin.Get("/usage", metricsKey, adminLimit, handleAdminUsage)
+	admin.Get("/sessions", adminKey, adminLimit, handleAdminListSessions)
+	admin.Get("/sessions/:sessionid", adminKey, adminLimit, handleAdminGetSession)
+	admin.Delete("/sessions/:sessionid", adminKey, adminLimit, handleAdminCloseSession)
+	admin.Get("/sessions/:sessionid/audit", adminKey, adminLimit, handleAdminSessionAudit)
+	admin.Post("/broadcast", adminKey, adminLimit, handleAdminBroadcast)
+	admin.Get("/events", adminKey, adminLimit, handleAdminEvents)
+	admin.Get("/webhooks/deliveries", metricsKey, adminLimit, handleAdminWebhookDeliveries)
+	admin.Get("/janitor", metricsKey, adminLimit, handleAdminJanitor)
 
 	app.Use("/", filesystem.New(filesystem.Config{
 		Root:         http.FS(webembed.Static),
 		NotFoundFile: "index.html", // let the frontend handle
 	}))
 
-	addr := fmt.Sprintf("%s:%d", config.C.APIHost, config.C.APIPort)
+	addr := fmt.Sprintf("%s:%d", config.C().APIHost, config.C().APIPort)
 	go func() {
 		if err := app.Listen(addr); err != nil {
 			slog.Error("Failed to start API server", "err", err)
@@ -86,6 +116,7 @@ func Serve(ctx context.Context) {
 	}()
 	<-ctx.Done()
 	slog.Info("API server is shutting down")
+	events.Default().Close()
 	if err := app.ShutdownWithTimeout(time.Second * 10); err != nil {
 		slog.Error("Failed to gracefully shutdown API server", "err", err)
 	} else {
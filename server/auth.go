@@ -0,0 +1,53 @@
+package server
+
+import (
+	"errors"
+	"remdit-server/config"
+	"remdit-server/service/apikey"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/middleware/keyauth"
+)
+
+var errMissingScope = errors.New("api key lacks required scope")
+
+// requireKey 校验 X-API-Key 并要求其拥有 scope, 通过后可用 apiKeyFromCtx 取得 key.
+// skip 返回 true 时跳过校验
+func requireKey(scope apikey.Scope, skip func(c *fiber.Ctx) bool) fiber.Handler {
+	return keyauth.New(keyauth.Config{
+		Next:      skip,
+		KeyLookup: "header:X-API-Key",
+		Validator: func(c *fiber.Ctx, s string) (bool, error) {
+			key, err := apikey.Authenticate(s)
+			if err != nil {
+				return false, err
+			}
+			if !key.HasScope(scope) {
+				return false, errMissingScope
+			}
+			c.Locals("apiKey", key)
+			return true, nil
+		},
+		ErrorHandler: func(c *fiber.Ctx, err error) error {
+			switch {
+			case errors.Is(err, errMissingScope):
+				return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": err.Error()})
+			case errors.Is(err, keyauth.ErrMissingOrMalformedAPIKey):
+				return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": "missing or malformed api key"})
+			case errors.Is(err, apikey.ErrExpiredKey), errors.Is(err, apikey.ErrRevokedKey):
+				return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": err.Error()})
+			}
+			return c.Status(fiber.StatusUnauthorized).JSON(fiber.Map{"error": apikey.ErrInvalidKey.Error()})
+		},
+	})
+}
+
+func skipWithoutKeyAuth(c *fiber.Ctx) bool {
+	return !config.C().APIKeyAuth
+}
+
+// apiKeyFromCtx 返回当前请求使用的 key, 未认证时为 nil
+func apiKeyFromCtx(c *fiber.Ctx) *apikey.Key {
+	key, _ := c.Locals("apiKey").(*apikey.Key)
+	return key
+}
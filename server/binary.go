@@ -0,0 +1,146 @@
+package server
+
+import (
+	"encoding/hex"
+	"fmt"
+	"log/slog"
+	"mime"
+	"remdit-server/service/stors/filestor"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const (
+	hexBytesPerRow   = 16
+	hexDefaultLength = 4096
+	hexMaxLength     = 64 * 1024
+)
+
+// HexRow 是十六进制视图中的一行
+type HexRow struct {
+	Offset int64  `json:"offset"`
+	Hex    string `json:"hex"`
+	ASCII  string `json:"ascii"`
+}
+
+// binaryView 返回浏览器查看二进制文件的方式: 图片直接显示, 其他文件显示十六进制视图
+func binaryView(f filestor.File) string {
+	if strings.HasPrefix(f.ContentType(), "image/") {
+		return "image"
+	}
+	return "hex"
+}
+
+// binaryFile 读取二进制文件的内容, 失败时返回响应状态码和错误
+func binaryFile(c *fiber.Ctx) (filestor.File, []byte, int, string) {
+	fileInfo := c.Locals("fileInfo").(filestor.File)
+	if !fileInfo.Binary() {
+		return nil, nil, fiber.StatusBadRequest, "file is not binary"
+	}
+	data, err := fileInfo.Read(c.Context())
+	if err != nil {
+		slog.Error("Failed to read file", "fileid", fileInfo.ID(), "err", err)
+		return nil, nil, fiber.StatusInternalServerError, "failed to read file"
+	}
+	return fileInfo, data, 0, ""
+}
+
+// handleGetRawFile 原样返回二进制文件的内容. 只有图片使用识别出的 Content-Type 内联显示,
+// 其他文件作为附件下载, 并禁止浏览器猜测类型和执行脚本
+func handleGetRawFile(c *fiber.Ctx) error {
+	fileInfo, data, status, msg := binaryFile(c)
+	if fileInfo == nil {
+		return c.Status(status).JSON(fiber.Map{"error": msg})
+	}
+	c.Set(fiber.HeaderXContentTypeOptions, "nosniff")
+	c.Set(fiber.HeaderContentSecurityPolicy, "default-src 'none'; sandbox")
+	c.Set(fiber.HeaderCacheControl, "no-store")
+	if binaryView(fileInfo) == "image" {
+		c.Set(fiber.HeaderContentType, fileInfo.ContentType())
+	} else {
+		c.Set(fiber.HeaderContentType, fiber.MIMEOctetStream)
+		c.Set(fiber.HeaderContentDisposition, attachment(fileInfo.Name()))
+	}
+	return c.Status(fiber.StatusOK).Send(data)
+}
+
+// attachment 按 RFC 6266 生成 Content-Disposition: filename 是 ASCII 的替代名称,
+// 名称中有其他字符时再用 filename* 给出 UTF-8 百分号编码的原名
+func attachment(name string) string {
+	fallback := strings.Map(func(r rune) rune {
+		if r < 0x20 || r >= 0x7f {
+			return '_'
+		}
+		return r
+	}, name)
+	v := mime.FormatMediaType("attachment", map[string]string{"filename": fallback})
+	if fallback == name {
+		return v
+	}
+	var sb strings.Builder
+	for _, b := range []byte(name) {
+		if isAttrChar(b) {
+			sb.WriteByte(b)
+		} else {
+			fmt.Fprintf(&sb, "%%%02X", b)
+		}
+	}
+	return v + "; filename*=UTF-8''" + sb.String()
+}
+
+// isAttrChar 报告 b 是否可以不经编码出现在 RFC 8187 的 ext-value 中
+func isAttrChar(b byte) bool {
+	switch {
+	case 'a' <= b && b <= 'z', 'A' <= b && b <= 'Z', '0' <= b && b <= '9':
+		return true
+	}
+	return strings.IndexByte("!#$&+-.^_`|~", b) >= 0
+}
+
+// handleGetHexFile 返回二进制文件 [offset, offset+length) 范围的十六进制视图
+func handleGetHexFile(c *fiber.Ctx) error {
+	fileInfo, data, status, msg := binaryFile(c)
+	if fileInfo == nil {
+		return c.Status(status).JSON(fiber.Map{"error": msg})
+	}
+	offset, err := strconv.ParseInt(c.Query("offset", "0"), 10, 64)
+	if err != nil || offset < 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid offset"})
+	}
+	length, err := strconv.ParseInt(c.Query("length", strconv.Itoa(hexDefaultLength)), 10, 64)
+	if err != nil || length <= 0 || length > hexMaxLength {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "length must be between 1 and " + strconv.Itoa(hexMaxLength)})
+	}
+	// 从整行开始
+	offset -= offset % hexBytesPerRow
+	size := int64(len(data))
+	end := min(offset+length, size)
+	rows := make([]HexRow, 0, (max(end-offset, 0)+hexBytesPerRow-1)/hexBytesPerRow)
+	for pos := offset; pos < end; pos += hexBytesPerRow {
+		chunk := data[pos:min(pos+hexBytesPerRow, end)]
+		ascii := make([]byte, len(chunk))
+		for i, b := range chunk {
+			if b < 0x20 || b > 0x7e {
+				b = '.'
+			}
+			ascii[i] = b
+		}
+		encoded := hex.EncodeToString(chunk)
+		parts := make([]string, 0, len(chunk))
+		for i := 0; i < len(encoded); i += 2 {
+			parts = append(parts, encoded[i:i+2])
+		}
+		rows = append(rows, HexRow{Offset: pos, Hex: strings.Join(parts, " "), ASCII: string(ascii)})
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{
+		"fileid":        fileInfo.ID(),
+		"content_type":  fileInfo.ContentType(),
+		"size":          size,
+		"offset":        offset,
+		"length":        max(end-offset, 0),
+		"bytes_per_row": hexBytesPerRow,
+		"rows":          rows,
+	})
+}
@@ -0,0 +1,31 @@
+package server
+
+import (
+	"mime"
+	"testing"
+)
+
+func TestAttachment(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"data.bin", `attachment; filename=data.bin`},
+		{"my data.bin", `attachment; filename="my data.bin"`},
+		{`a"b\c.bin`, `attachment; filename="a\"b\\c.bin"`},
+		{"café.bin", `attachment; filename=caf_.bin; filename*=UTF-8''caf%C3%A9.bin`},
+		{"日本 (1);x.zip", `attachment; filename="__ (1);x.zip"; filename*=UTF-8''%E6%97%A5%E6%9C%AC%20%281%29%3Bx.zip`},
+		{"tab\there", `attachment; filename=tab_here; filename*=UTF-8''tab%09here`},
+	}
+	for _, tt := range tests {
+		got := attachment(tt.name)
+		if got != tt.want {
+			t.Errorf("attachment(%q) = %s, want %s", tt.name, got, tt.want)
+			continue
+		}
+		// 标准库按 RFC 2231 解析时应优先得到原名
+		if _, params, err := mime.ParseMediaType(got); err != nil || params["filename"] != tt.name {
+			t.Errorf("ParseMediaType(%s) = %q, %v, want %q", got, params["filename"], err, tt.name)
+		}
+	}
+}
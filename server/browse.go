@@ -0,0 +1,283 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"log/slog"
+	"path"
+	"remdit-server/config"
+	"remdit-server/service/audit"
+	"remdit-server/service/editorconfig"
+	"remdit-server/service/events"
+	"remdit-server/service/quota"
+	"remdit-server/service/stors/filestor"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+var errRequestTimeout = errors.New("timeout waiting for client response")
+
+// clientError 是客户端程序在回复中报告的错误
+type clientError struct {
+	reason string
+}
+
+func (e *clientError) Error() string {
+	return "client error: " + e.reason
+}
+
+// DirEntry 是客户端程序返回的目录项, 已经打开过的文件带有 FileID
+type DirEntry struct {
+	Name   string `json:"name"`
+	Path   string `json:"path"`
+	Type   string `json:"type"`
+	Size   int64  `json:"size,omitempty"`
+	FileID string `json:"fileid,omitempty"`
+}
+
+type OpenFileRequest struct {
+	Path string `json:"path"`
+}
+
+// matchSegments 按路径段匹配, 模式中的 "**" 匹配剩余的所有路径段
+func matchSegments(pattern, segs []string) bool {
+	for i, p := range pattern {
+		if p == "**" {
+			return true
+		}
+		if i >= len(segs) {
+			return false
+		}
+		if ok, _ := path.Match(p, segs[i]); !ok {
+			return false
+		}
+	}
+	return len(pattern) == len(segs)
+}
+
+// pathAllowed 判断 p 是否匹配会话声明的某个路径模式
+func pathAllowed(patterns []string, p string) bool {
+	segs := strings.Split(p, "/")
+	for _, pattern := range patterns {
+		if matchSegments(strings.Split(pattern, "/"), segs) {
+			return true
+		}
+	}
+	return false
+}
+
+// dirVisible 判断目录是否可以浏览: 目录本身被允许, 或者它是某个模式的上级目录
+func dirVisible(patterns []string, dir string) bool {
+	if dir == "." {
+		return len(patterns) > 0
+	}
+	if pathAllowed(patterns, dir) {
+		return true
+	}
+	segs := strings.Split(dir, "/")
+	for _, pattern := range patterns {
+		ps := strings.Split(pattern, "/")
+		if len(ps) > len(segs) && matchSegments(ps[:len(segs)], segs) {
+			return true
+		}
+	}
+	return false
+}
+
+// browseSession 返回会话的第一个文件和 hub, 失败时返回响应状态码和错误
+func browseSession(c *fiber.Ctx) (filestor.File, *EditingHub, int, string) {
+	id := c.Params("sessionid")
+	primary := filestor.Get(c.Context(), id)
+	if primary == nil || primary.SessionID() != id {
+		return nil, nil, fiber.StatusNotFound, "session not found"
+	}
+	if len(primary.AllowedPaths()) == 0 {
+		return nil, nil, fiber.StatusForbidden, "the client did not allow browsing"
+	}
+	hub := hubManager.GetHub(id)
+	if hub == nil || hub.sessionConn == nil {
+		return nil, nil, fiber.StatusNotFound, "editing hub not found"
+	}
+	return primary, hub, 0, ""
+}
+
+func requestError(c *fiber.Ctx, err error) error {
+	var ce *clientError
+	switch {
+	case errors.As(err, &ce):
+		return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{"error": err.Error()})
+	case errors.Is(err, errRequestTimeout):
+		return c.Status(fiber.StatusGatewayTimeout).JSON(fiber.Map{"error": err.Error()})
+	}
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to reach client", "reason": err.Error()})
+}
+
+// handleListDir 通过客户端程序列出目录, 只返回会话声明允许的路径
+func handleListDir(c *fiber.Ctx) error {
+	primary, hub, status, msg := browseSession(c)
+	if primary == nil {
+		return c.Status(status).JSON(fiber.Map{"error": msg})
+	}
+	dir := "."
+	if p := c.Query("path"); p != "" && p != "." {
+		cleaned, err := cleanSessionPath(p)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		}
+		dir = cleaned
+	}
+	patterns := primary.AllowedPaths()
+	if !dirVisible(patterns, dir) {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "path is not allowed"})
+	}
+
+	resp, err := hub.requestSession(map[string]any{"type": "list_dir", "path": dir})
+	if err != nil {
+		slog.Warn("list_dir request failed", "sessionid", hub.id, "path", dir, "err", err)
+		return requestError(c, err)
+	}
+	opened := make(map[string]string)
+	for _, f := range filestor.ListSession(c.Context(), hub.id) {
+		opened[f.Path()] = f.ID()
+	}
+	raw, _ := resp["entries"].([]any)
+	entries := make([]DirEntry, 0, len(raw))
+	for _, item := range raw {
+		m, ok := item.(map[string]any)
+		if !ok {
+			continue
+		}
+		name, _ := m["name"].(string)
+		typ, _ := m["type"].(string)
+		if name == "" || name == "." || name == ".." || strings.ContainsAny(name, "/\\") {
+			continue
+		}
+		p := path.Join(dir, name)
+		if typ != "dir" {
+			typ = "file"
+		}
+		if (typ == "dir" && !dirVisible(patterns, p)) || (typ == "file" && !pathAllowed(patterns, p)) {
+			continue
+		}
+		size, _ := m["size"].(float64)
+		entry := DirEntry{Name: name, Path: p, Type: typ, Size: int64(size)}
+		if meta, err := filestor.SanitizePath(p); err == nil {
+			entry.FileID = opened[meta]
+		}
+		entries = append(entries, entry)
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"path": dir, "entries": entries})
+}
+
+// handleOpenFile 通过客户端程序读取文件并作为新文件加入会话, 已打开的文件直接返回
+func handleOpenFile(c *fiber.Ctx) error {
+	primary, hub, status, msg := browseSession(c)
+	if primary == nil {
+		return c.Status(status).JSON(fiber.Map{"error": msg})
+	}
+	var req OpenFileRequest
+	if err := c.BodyParser(&req); err != nil || req.Path == "" {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "path is required"})
+	}
+	p, err := cleanSessionPath(req.Path)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+	if !pathAllowed(primary.AllowedPaths(), p) {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "path is not allowed"})
+	}
+	// p 原样发给客户端程序, 会话中只保存规范化后的路径
+	meta, err := filestor.SanitizePath(p)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	hub.openMu.Lock()
+	defer hub.openMu.Unlock()
+	files := filestor.ListSession(c.Context(), hub.id)
+	var total int64
+	for _, f := range files {
+		if f.Path() == meta {
+			return c.Status(fiber.StatusOK).JSON(fiber.Map{
+				"cached": true,
+				"file":   SessionFile{FileID: f.ID(), Path: f.Path(), Size: f.Size(), Revision: f.Revision(), Binary: f.Binary(), EditURL: editURL(f.ID())},
+			})
+		}
+		total += f.Size()
+	}
+	if len(files) >= config.MaxSessionFiles {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "session already contains the maximum number of files"})
+	}
+
+	resp, err := hub.requestSession(map[string]any{"type": "open_file", "path": p})
+	if err != nil {
+		slog.Warn("open_file request failed", "sessionid", hub.id, "path", p, "err", err)
+		return requestError(c, err)
+	}
+	text, ok := resp["content"].(string)
+	if !ok {
+		return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{"error": "client returned no content"})
+	}
+	// 不是 UTF-8 的文件由客户端程序以 base64 原样发送
+	content := []byte(text)
+	if b64, _ := resp["base64"].(bool); b64 {
+		if content, err = base64.StdEncoding.DecodeString(text); err != nil {
+			return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{"error": "client returned invalid base64 content"})
+		}
+	}
+	size := int64(len(content))
+	if size > config.C().MaxFileSize() {
+		return c.Status(fiber.StatusRequestEntityTooLarge).JSON(fiber.Map{"error": "file size exceeds limit"})
+	}
+
+	var opts []filestor.Option
+	if !primary.E2E() {
+		if opts, err = contentOptions(content); err != nil {
+			return c.Status(fiber.StatusUnsupportedMediaType).JSON(fiber.Map{"error": err.Error()})
+		}
+	}
+	// 客户端程序可以在回复中附带为该文件解析好的 EditorConfig 属性
+	var explicit editorconfig.Properties
+	if raw, ok := resp["editorconfig"].(map[string]any); ok {
+		if explicit, err = editorConfigProps(raw); err != nil {
+			return c.Status(fiber.StatusBadGateway).JSON(fiber.Map{"error": "client returned invalid editorconfig: " + err.Error()})
+		}
+	}
+	opts = append(opts, filestor.WithEditorConfig(resolveEditorConfig(primary.EditorConfigSources(), explicit, meta), nil))
+	// 内容和属性都校验通过后才占用配额, 之后失败的路径都要归还
+	if err := quota.Resize(hub.id, total+size, size); err != nil {
+		return quotaError(c, err)
+	}
+	f := newSessionFile(hub.id, uuid.New().String(), meta, primary.Origin(), primary.E2E(), opts...)
+	if err := f.Write(c.Context(), content); err != nil {
+		quota.Restore(hub.id, total)
+		slog.Error("Failed to store file", "fileid", f.ID(), "err", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to save file"})
+	}
+	if err := filestor.Save(context.Background(), f.ID(), f); err != nil {
+		quota.Restore(hub.id, total)
+		f.Remove()
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to save file info"})
+	}
+	slog.Info("Opened file through client", "sessionid", hub.id, "fileid", f.ID(), "path", p, "size", size)
+	// 与 createSession 中的文件一样记录审计和事件, 并通知其他房间的浏览器更新文件列表
+	digest := sha256.Sum256(content)
+	audit.Record(audit.Entry{
+		Action:    audit.FileAdded,
+		SessionID: hub.id,
+		IP:        clientIP(c),
+		KeyName:   primary.Origin().KeyName,
+		Filename:  meta,
+		Size:      size,
+		SHA256:    hex.EncodeToString(digest[:]),
+	})
+	events.Publish(events.FileAdded, hub.id, map[string]any{"fileid": f.ID(), "path": meta, "size": size, "ip": clientIP(c)})
+	file := SessionFile{FileID: f.ID(), Path: f.Path(), Size: f.Size(), Revision: f.Revision(), Binary: f.Binary(), EditURL: editURL(f.ID())}
+	hub.BroadcastFileAdded(file)
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"cached": false, "file": file})
+}
@@ -0,0 +1,126 @@
+package server
+
+import (
+	"encoding/json"
+	"fmt"
+	"maps"
+	"mime/multipart"
+	"path"
+	"remdit-server/config"
+	"remdit-server/service/editorconfig"
+	"remdit-server/service/stors/filestor"
+	"remdit-server/service/textenc"
+	"remdit-server/service/textfmt"
+)
+
+// 单个 .editorconfig 文件的大小上限
+const maxEditorConfigSize = 64 * 1024
+
+// parseEditorConfigForm 读取创建会话时附带的 EditorConfig:
+// editorconfig_file 是原始的 .editorconfig 文件, editorconfig_path 给出它们在会话中的路径;
+// editorconfig 是客户端程序已经解析好的属性 (JSON 对象), 只给一次时作用于所有文件
+func parseEditorConfigForm(form *multipart.Form) ([]editorconfig.Source, []editorconfig.Properties, error) {
+	files, paths := form.File["editorconfig_file"], form.Value["editorconfig_path"]
+	if len(files) > config.MaxSessionFiles {
+		return nil, nil, fmt.Errorf("at most %d editorconfig files are allowed", config.MaxSessionFiles)
+	}
+	if len(paths) > 0 && len(paths) != len(files) {
+		return nil, nil, fmt.Errorf("editorconfig_path must be given once for every editorconfig_file")
+	}
+	sources := make([]editorconfig.Source, 0, len(files))
+	for i, file := range files {
+		if file.Size > maxEditorConfigSize {
+			return nil, nil, fmt.Errorf("editorconfig file is too large")
+		}
+		p := file.Filename
+		if len(paths) > 0 {
+			p = paths[i]
+		}
+		data, err := readFormFile(file)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to read editorconfig file")
+		}
+		src, err := editorConfigSource(p, data)
+		if err != nil {
+			return nil, nil, err
+		}
+		sources = append(sources, src)
+	}
+
+	var props []editorconfig.Properties
+	for _, v := range form.Value["editorconfig"] {
+		var raw map[string]any
+		if err := json.Unmarshal([]byte(v), &raw); err != nil {
+			return nil, nil, fmt.Errorf("editorconfig must be a JSON object")
+		}
+		p, err := editorConfigProps(raw)
+		if err != nil {
+			return nil, nil, err
+		}
+		props = append(props, p)
+	}
+	return sources, props, nil
+}
+
+// editorConfigSource 解析位于会话路径 p 的 .editorconfig 文件
+func editorConfigSource(p string, data []byte) (editorconfig.Source, error) {
+	if len(data) > maxEditorConfigSize {
+		return editorconfig.Source{}, fmt.Errorf("editorconfig file is too large")
+	}
+	// 与文件路径使用相同的规范化, 目录才能对应上
+	meta, err := filestor.SanitizePath(p)
+	if err != nil {
+		return editorconfig.Source{}, fmt.Errorf("invalid editorconfig path: %w", err)
+	}
+	ec, err := editorconfig.Parse(data)
+	if err != nil {
+		return editorconfig.Source{}, fmt.Errorf("invalid editorconfig file %q: %w", meta, err)
+	}
+	return editorconfig.Source{Dir: path.Dir(meta), File: ec}, nil
+}
+
+// editorConfigProps 把 JSON 中的属性转换为字符串形式
+func editorConfigProps(raw map[string]any) (editorconfig.Properties, error) {
+	values := make(map[string]string, len(raw))
+	for k, val := range raw {
+		switch val.(type) {
+		case string, bool, float64:
+			values[k] = fmt.Sprint(val)
+		default:
+			return nil, fmt.Errorf("editorconfig property %q must be a string, number or boolean", k)
+		}
+	}
+	return editorconfig.Normalize(values), nil
+}
+
+// resolveEditorConfig 计算文件 p 的属性, 客户端程序直接给出的属性优先于 .editorconfig 文件
+func resolveEditorConfig(sources []editorconfig.Source, explicit editorconfig.Properties, p string) editorconfig.Properties {
+	props := editorconfig.Resolve(sources, p)
+	maps.Copy(props, explicit)
+	if len(props) == 0 {
+		return nil
+	}
+	return props
+}
+
+// 保存时的优先级: 保存请求中显式给出的字段 > EditorConfig 属性 > 上传时识别的编码和格式.
+// fileEncoding 和 fileFormat 计算后两者, 即不带请求覆盖时生效的值
+
+// fileEncoding 返回文件生效的字符编码, EditorConfig 的 charset 覆盖识别结果
+func fileEncoding(f filestor.File) textenc.Encoding {
+	if props := f.EditorConfig(); props != nil {
+		return props.Encoding(f.Encoding())
+	}
+	return f.Encoding()
+}
+
+// fileFormat 返回文件生效的文本格式, EditorConfig 的属性覆盖识别结果
+func fileFormat(f filestor.File) textfmt.Format {
+	tf := f.TextFormat()
+	// 识别出的缩进只用于显示, EditorConfig 或保存请求指定了缩进才转换
+	tf.ConvertIndent = false
+	if props := f.EditorConfig(); props != nil {
+		return props.Format(tf)
+	}
+	return tf
+}
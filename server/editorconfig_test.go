@@ -0,0 +1,88 @@
+package server
+
+import (
+	"remdit-server/service/editorconfig"
+	"remdit-server/service/stors/filestor"
+	"remdit-server/service/textenc"
+	"remdit-server/service/textfmt"
+	"testing"
+)
+
+func testFile(props editorconfig.Properties) filestor.File {
+	return filestor.NewFile(nil, "f", "s/f", "a.txt", filestor.Origin{},
+		filestor.WithTextEncoding(textenc.Encoding{Name: textenc.UTF8}),
+		filestor.WithTextFormat(textfmt.Format{LineEnding: textfmt.LF, FinalNewline: true, Indent: textfmt.IndentSpace, IndentSize: 2}),
+		filestor.WithEditorConfig(props, nil),
+	)
+}
+
+func TestSaveEncodingPrecedence(t *testing.T) {
+	bom := false
+	tests := []struct {
+		name  string
+		props editorconfig.Properties
+		req   FileSaveRequest
+		want  textenc.Encoding
+	}{
+		{"detected", nil, FileSaveRequest{}, textenc.Encoding{Name: textenc.UTF8}},
+		{"editorconfig over detected", editorconfig.Properties{"charset": "latin1"}, FileSaveRequest{}, textenc.Encoding{Name: textenc.Windows1252}},
+		{"request over editorconfig", editorconfig.Properties{"charset": "latin1"}, FileSaveRequest{Encoding: "utf-8"}, textenc.Encoding{Name: textenc.UTF8}},
+		{"request bom over editorconfig", editorconfig.Properties{"charset": "utf-8-bom"}, FileSaveRequest{BOM: &bom}, textenc.Encoding{Name: textenc.UTF8}},
+		{"editorconfig bom kept", editorconfig.Properties{"charset": "utf-8-bom"}, FileSaveRequest{Encoding: "utf-8"}, textenc.Encoding{Name: textenc.UTF8, BOM: true}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := saveEncoding(testFile(tt.props), tt.req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Fatalf("saveEncoding = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSaveFormatPrecedence(t *testing.T) {
+	noFinal := false
+	tests := []struct {
+		name  string
+		props editorconfig.Properties
+		req   FileSaveRequest
+		want  textfmt.Format
+	}{
+		{
+			"detected indent is not converted", nil, FileSaveRequest{},
+			textfmt.Format{LineEnding: textfmt.LF, FinalNewline: true, Indent: textfmt.IndentSpace, IndentSize: 2},
+		},
+		{
+			"editorconfig over detected",
+			editorconfig.Properties{"end_of_line": "crlf", "indent_style": "tab", "tab_width": "8", "insert_final_newline": "false"},
+			FileSaveRequest{},
+			textfmt.Format{LineEnding: textfmt.CRLF, Indent: textfmt.IndentTab, IndentSize: 8, ConvertIndent: true},
+		},
+		{
+			"request over editorconfig",
+			editorconfig.Properties{"end_of_line": "crlf", "indent_style": "tab", "tab_width": "8", "insert_final_newline": "true"},
+			FileSaveRequest{LineEnding: "lf", Indent: "space", IndentSize: 4, FinalNewline: &noFinal},
+			textfmt.Format{LineEnding: textfmt.LF, Indent: textfmt.IndentSpace, IndentSize: 4, ConvertIndent: true},
+		},
+		{
+			"request fills in what editorconfig leaves out",
+			editorconfig.Properties{"end_of_line": "cr"},
+			FileSaveRequest{Indent: "tab"},
+			textfmt.Format{LineEnding: textfmt.CR, FinalNewline: true, Indent: textfmt.IndentTab, IndentSize: 2, ConvertIndent: true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := saveFormat(testFile(tt.props), tt.req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Fatalf("saveFormat = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
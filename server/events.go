@@ -0,0 +1,70 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"remdit-server/service/events"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+const sseHeartbeatInterval = 15 * time.Second
+
+// handleAdminEvents 以 Server-Sent Events 推送服务器事件, 支持 ?session= 和 ?type=a,b 过滤
+func handleAdminEvents(c *fiber.Ctx) error {
+	filter := events.Filter{SessionID: c.Query("session")}
+	if types := c.Query("type"); types != "" {
+		for _, t := range strings.Split(types, ",") {
+			et := events.Type(strings.TrimSpace(t))
+			if !slices.Contains(events.AllTypes, et) {
+				return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": fmt.Sprintf("unknown event type %q", t)})
+			}
+			filter.Types = append(filter.Types, et)
+		}
+	}
+
+	c.Set(fiber.HeaderContentType, "text/event-stream")
+	c.Set(fiber.HeaderCacheControl, "no-cache")
+	c.Set(fiber.HeaderConnection, "keep-alive")
+	c.Set("X-Accel-Buffering", "no")
+
+	sub := events.Subscribe(filter, 256)
+	keyName := apiKeyFromCtx(c).Name
+	slog.Info("Event stream subscribed", "key", keyName, "session", filter.SessionID, "types", filter.Types)
+
+	c.Context().SetBodyStreamWriter(func(w *bufio.Writer) {
+		defer sub.Close()
+		heartbeat := time.NewTicker(sseHeartbeatInterval)
+		defer heartbeat.Stop()
+
+		fmt.Fprint(w, ": connected\n\n")
+		if err := w.Flush(); err != nil {
+			return
+		}
+		for {
+			select {
+			case e, ok := <-sub.C():
+				if !ok {
+					return
+				}
+				data, err := json.Marshal(e)
+				if err != nil {
+					continue
+				}
+				fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, data)
+			case <-heartbeat.C:
+				fmt.Fprint(w, ": heartbeat\n\n")
+			}
+			if err := w.Flush(); err != nil {
+				slog.Info("Event stream closed", "key", keyName, "dropped", sub.Dropped())
+				return
+			}
+		}
+	})
+	return nil
+}
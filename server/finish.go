@@ -0,0 +1,126 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"log/slog"
+	"remdit-server/service/events"
+	"remdit-server/service/stors/filestor"
+	"remdit-server/service/textenc"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// 会话由浏览器正常结束时, 浏览器和客户端程序收到的关闭码
+const (
+	closeSessionCompleted       = 4001
+	closeReasonSessionCompleted = "session completed"
+)
+
+// finishSession 让客户端程序做最后一次保存并退出, 成功后以 closeSessionCompleted 关闭所有连接
+func finishSession(ctx context.Context, hub *EditingHub, fileInfo filestor.File, content *string, ip, participant string) (int, fiber.Map) {
+	if !hub.finishing.CompareAndSwap(false, true) {
+		return fiber.StatusConflict, fiber.Map{"error": "session is already finishing"}
+	}
+	if fileInfo.Binary() && content != nil {
+		hub.finishing.Store(false)
+		return fiber.StatusConflict, fiber.Map{"error": "binary files are read-only"}
+	}
+	var raw []byte
+	if content == nil {
+		data, err := fileInfo.Read(ctx)
+		if err != nil {
+			hub.finishing.Store(false)
+			slog.Error("Failed to read file", "fileid", fileInfo.ID(), "err", err)
+			return fiber.StatusInternalServerError, fiber.Map{"error": "failed to read file"}
+		}
+		s := string(data)
+		if fileInfo.Binary() {
+			raw = data
+		} else if !fileInfo.E2E() {
+			if s, err = textenc.Decode(data, fileInfo.Encoding()); err != nil {
+				hub.finishing.Store(false)
+				slog.Error("Failed to decode file", "fileid", fileInfo.ID(), "err", err)
+				return fiber.StatusInternalServerError, fiber.Map{"error": "failed to decode file"}
+			}
+		}
+		content = &s
+	}
+	// 结束会话沿用文件原来的编码和格式, 与不带覆盖项的 PUT 相同
+	enc, err := saveEncoding(fileInfo, FileSaveRequest{})
+	if err != nil {
+		hub.finishing.Store(false)
+		return fiber.StatusBadRequest, fiber.Map{"error": err.Error()}
+	}
+	tf, err := saveFormat(fileInfo, FileSaveRequest{})
+	if err != nil {
+		hub.finishing.Store(false)
+		return fiber.StatusBadRequest, fiber.Map{"error": err.Error()}
+	}
+	// 客户端程序发出 save_result 后立即退出, 会话连接的清理可能先于这里完成,
+	// 所以在发出结束消息前设置关闭码, 保存失败时再恢复
+	prevCode, prevReason := hub.closeStatus()
+	hub.setCloseStatus(closeSessionCompleted, closeReasonSessionCompleted)
+	status, body := saveRequest{
+		hub:         hub,
+		file:        fileInfo,
+		content:     *content,
+		raw:         raw,
+		encoding:    enc,
+		format:      tf,
+		ip:          ip,
+		participant: participant,
+		finish:      true,
+	}.run(ctx)
+	if status != fiber.StatusOK {
+		// 保存失败时会话保持打开, 浏览器可以重试
+		hub.setCloseStatus(prevCode, prevReason)
+		hub.finishing.Store(false)
+		return status, body
+	}
+
+	slog.Info("Session finished from browser", "sessionid", hub.id, "fileid", fileInfo.ID(), "participantid", participant)
+	events.Publish(events.SessionFinished, hub.id, map[string]any{"fileid": fileInfo.ID(), "participantid": participant, "ip": ip})
+	hubManager.CleanupSession(hub.id)
+	return fiber.StatusOK, fiber.Map{"message": "session finished"}
+}
+
+func handleFinishSession(c *fiber.Ctx) error {
+	fileInfo := c.Locals("fileInfo").(filestor.File)
+	hub := hubManager.GetHub(fileInfo.SessionID())
+	if hub == nil || hub.sessionConn == nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "editing hub not found"})
+	}
+	var req FinishRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request body"})
+		}
+	}
+	status, body := finishSession(c.Context(), hub, fileInfo, req.Content, clientIP(c), participantID(c, hub, fileInfo.ID()))
+	return c.Status(status).JSON(body)
+}
+
+// handleRoomCommand 处理浏览器通过房间连接发送的 JSON 文本消息
+func handleRoomCommand(hub *EditingHub, client *WSEditingClient, ip string, msg []byte) {
+	var req FinishRequest
+	if err := json.Unmarshal(msg, &req); err != nil {
+		slog.Debug("Ignoring malformed room message", "room", hub.id, "err", err)
+		return
+	}
+	if req.Type != "finish" {
+		return
+	}
+	fileInfo := filestor.Get(context.Background(), client.room)
+	if fileInfo == nil {
+		return
+	}
+	status, body := finishSession(context.Background(), hub, fileInfo, req.Content, ip, client.id)
+	if status != fiber.StatusOK {
+		reason, _ := body["error"].(string)
+		if detail, ok := body["reason"].(string); ok {
+			reason += ": " + detail
+		}
+		client.sendJSON(NoticeMessage{Type: "notice", Level: "error", Message: "Failed to finish session: " + reason})
+	}
+}
@@ -1,20 +1,30 @@
 package server
 
 import (
+	"context"
+	crand "crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
 	"log/slog"
-	"math/rand"
-	"os"
+	"mime/multipart"
 	"path/filepath"
 	"remdit-server/config"
+	"remdit-server/service/audit"
+	"remdit-server/service/events"
+	"remdit-server/service/quota"
 	"remdit-server/service/stors/filestor"
+	"remdit-server/service/textenc"
+	"remdit-server/service/textfmt"
+	"strconv"
 	"strings"
 	"time"
 
 	"github.com/gofiber/contrib/websocket"
 	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
 	"github.com/google/uuid"
 )
 
@@ -32,12 +42,13 @@ func handleRoomWSUpgrade(c *fiber.Ctx) error {
 		if fileInfo == nil {
 			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "file not found"})
 		}
-		hub := hubManager.GetHub(room)
+		hub := hubManager.GetHub(fileInfo.SessionID())
 		if hub == nil || hub.sessionConn == nil {
 			slog.Error("No editing hub found for room", "room", room)
 			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "editing hub not found"})
 		}
-		slog.Info("WebSocket connection request", "room", room, "fileid", fileID)
+		slog.Info("WebSocket connection request", "room", room, "sessionid", fileInfo.SessionID())
+		c.Locals("clientIP", clientIP(c))
 		return c.Next()
 	}
 	return fiber.ErrUpgradeRequired
@@ -45,15 +56,30 @@ func handleRoomWSUpgrade(c *fiber.Ctx) error {
 
 func handleRoomWSConn(conn *websocket.Conn) {
 	room := conn.Params("room")
-	hub := hubManager.GetHub(room)
+	var hub *EditingHub
+	if fileInfo := filestor.Get(context.Background(), room); fileInfo != nil {
+		hub = hubManager.GetHub(fileInfo.SessionID())
+	}
 	if hub == nil || hub.sessionConn == nil {
 		slog.Error("No editing hub found for room", "room", room)
 		conn.Close()
 		return
 	}
-	client := hub.AddClientConn(conn)
+	client := hub.AddClientConn(conn, room)
 	defer client.Close()
 
+	participant := map[string]any{"participantid": client.id, "fileid": room, "addr": conn.RemoteAddr().String()}
+	events.Publish(events.ParticipantJoined, hub.id, participant)
+	defer events.Publish(events.ParticipantLeft, hub.id, participant)
+	ip, _ := conn.Locals("clientIP").(string)
+	audit.Record(audit.Entry{
+		Action:        audit.RoomJoined,
+		SessionID:     hub.id,
+		IP:            ip,
+		ParticipantID: client.id,
+	})
+	client.sendJSON(WelcomeMessage{Type: "welcome", ParticipantID: client.id})
+
 	var pingFailureCount int
 	var lastPongTime = time.Now()
 
@@ -124,10 +150,14 @@ func handleRoomWSConn(conn *websocket.Conn) {
 			break
 		}
 
+		if mt == websocket.TextMessage {
+			go handleRoomCommand(hub, client, ip, msg)
+			continue
+		}
 		if mt != websocket.BinaryMessage {
 			continue
 		}
-		hub.BroadcastMessage(msg)
+		hub.BroadcastMessage(room, msg)
 	}
 }
 
@@ -158,38 +188,225 @@ func handlePutFile(c *fiber.Ctx) error {
 		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "file not found"})
 	}
 
-	hub := hubManager.GetHub(fileID)
+	hub := hubManager.GetHub(fileInfo.SessionID())
 	if hub == nil || hub.sessionConn == nil {
 		slog.Error("No editing hub found for file", "fileid", fileID)
 		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "editing hub not found"})
 	}
+	if fileInfo.Binary() {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "binary files are read-only"})
+	}
+	if fileSaveReq.Revision > 0 && fileSaveReq.Revision != fileInfo.Revision() {
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": "file was changed by another save", "revision": fileInfo.Revision()})
+	}
+	if len(fileSaveReq.Patches) > 0 {
+		if fileInfo.E2E() {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "patch saves are not supported for e2e files"})
+		}
+		if fileSaveReq.Revision == 0 {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "revision is required for patch saves"})
+		}
+	}
+	enc, err := saveEncoding(fileInfo, fileSaveReq)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+	format, err := saveFormat(fileInfo, fileSaveReq)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+
+	status, body := saveRequest{
+		hub:         hub,
+		file:        fileInfo,
+		content:     fileSaveReq.Content,
+		patches:     fileSaveReq.Patches,
+		revision:    fileSaveReq.Revision,
+		encoding:    enc,
+		format:      format,
+		ip:          clientIP(c),
+		participant: participantID(c, hub, fileInfo.ID()),
+	}.run(c.Context())
+	return c.Status(status).JSON(body)
+}
 
-	slog.Info("Saving file", "fileid", fileID, "content_length", len(fileSaveReq.Content))
+// saveEncoding 返回保存时使用的字符编码, 请求中的字段优先, 没有给出时沿用 fileEncoding
+func saveEncoding(f filestor.File, req FileSaveRequest) (textenc.Encoding, error) {
+	enc := fileEncoding(f)
+	if req.Encoding != "" {
+		name, err := textenc.ParseName(req.Encoding)
+		if err != nil {
+			return enc, err
+		}
+		if name != enc.Name {
+			enc = textenc.Encoding{Name: name}
+		}
+	}
+	if req.BOM != nil {
+		enc.BOM = *req.BOM
+	}
+	if enc.BOM && enc.Name != textenc.UTF8 && enc.Name != textenc.UTF16LE && enc.Name != textenc.UTF16BE {
+		return enc, fmt.Errorf("encoding %s does not use a byte order mark", enc.Name)
+	}
+	return enc, nil
+}
+
+// saveFormat 返回保存时使用的文本格式, 请求中的字段优先, 没有指定的部分沿用 fileFormat
+func saveFormat(f filestor.File, req FileSaveRequest) (textfmt.Format, error) {
+	tf := fileFormat(f)
+	if req.LineEnding != "" {
+		le, err := textfmt.ParseLineEnding(req.LineEnding)
+		if err != nil {
+			return tf, err
+		}
+		tf.LineEnding = le
+	}
+	if req.FinalNewline != nil {
+		tf.FinalNewline = *req.FinalNewline
+	}
+	if req.Indent != "" {
+		indent, err := textfmt.ParseIndent(req.Indent)
+		if err != nil {
+			return tf, err
+		}
+		tf.Indent, tf.ConvertIndent = indent, true
+	}
+	if req.IndentSize < 0 || req.IndentSize > 16 {
+		return tf, fmt.Errorf("indent_size must be between 1 and 16")
+	}
+	if req.IndentSize > 0 {
+		tf.IndentSize = req.IndentSize
+	}
+	return tf, nil
+}
+
+// saveRequest 是一次保存, 来自 PUT /file 或浏览器结束会话
+type saveRequest struct {
+	hub         *EditingHub
+	file        filestor.File
+	content     string // 编辑器中的 UTF-8 文本, e2e 会话为密文
+	raw         []byte // 不为 nil 时原样保存, 用于结束会话时回写二进制文件
+	patches     []LinePatch
+	revision    int64 // patches 所基于的版本
+	encoding    textenc.Encoding
+	format      textfmt.Format
+	ip          string
+	participant string
+	finish      bool // 要求客户端程序保存后退出
+}
+
+// run 写入内容并等待客户端程序确认, 返回响应的状态码和内容
+func (r saveRequest) run(ctx context.Context) (int, fiber.Map) {
+	// 客户端程序的 save_result 不带文件 ID, 同一会话的保存需要依次进行
+	r.hub.saveMu.Lock()
+	defer r.hub.saveMu.Unlock()
+	fileID, sessionID := r.file.ID(), r.file.SessionID()
+	if len(r.patches) > 0 {
+		// 持有 saveMu 时再检查版本, 保证 patch 应用在它所基于的内容上
+		if r.file.Revision() != r.revision {
+			return fiber.StatusConflict, fiber.Map{"error": "file was changed by another save", "revision": r.file.Revision()}
+		}
+		content, status, body := patchedContent(ctx, r.file, r.patches)
+		if body != nil {
+			return status, body
+		}
+		r.content = content
+	}
+	// 保存和发给客户端程序的都是原始编码的内容, e2e 的密文不做转换
+	data := []byte(r.content)
+	if r.raw != nil {
+		data = r.raw
+	} else if !r.file.E2E() {
+		var err error
+		if data, err = textenc.Encode(textfmt.Normalize(r.content, r.format), r.encoding); err != nil {
+			return fiber.StatusUnprocessableEntity, fiber.Map{"error": err.Error(), "encoding": r.encoding}
+		}
+	}
+	if int64(len(data)) > config.C().MaxFileSize() {
+		return fiber.StatusRequestEntityTooLarge, fiber.Map{"error": "file size exceeds limit"}
+	}
+	// 旧的客户端程序会把 base64 文本原样写入文件, 在保存前拒绝
+	if needsBase64(r.file.E2E(), r.raw != nil, r.encoding) && !r.hub.Supports(capBase64) {
+		return fiber.StatusUnprocessableEntity, fiber.Map{"error": "the connected client cannot save binary or non-UTF-8 content, update it to a version that supports base64", "encoding": r.encoding}
+	}
+	digest := sha256.Sum256(data)
+	saveAudit := audit.Entry{
+		Action:        audit.SaveAttempted,
+		SessionID:     sessionID,
+		IP:            r.ip,
+		ParticipantID: r.participant,
+		Filename:      r.file.Path(),
+		Size:          int64(len(data)),
+		SHA256:        hex.EncodeToString(digest[:]),
+	}
+	// prevTotal 是保存前会话的总大小, 保存失败时恢复配额用量
+	total, prevTotal := int64(len(data)), int64(0)
+	for _, f := range filestor.ListSession(ctx, sessionID) {
+		prevTotal += f.Size()
+		if f.ID() != fileID {
+			total += f.Size()
+		}
+	}
+	resized := false
+	saveFailed := func(reason string) {
+		if resized {
+			quota.Restore(sessionID, prevTotal)
+		}
+		events.Publish(events.SaveFailed, sessionID, map[string]any{"fileid": fileID, "path": r.file.Path(), "size": len(data), "reason": reason})
+		saveAudit.Result, saveAudit.Reason = "failure", reason
+		audit.Record(saveAudit)
+	}
+
+	if err := quota.Resize(sessionID, total, int64(len(data))); err != nil {
+		slog.Warn("Save rejected by key quota", "fileid", fileID, "err", err)
+		saveFailed(err.Error())
+		return quotaStatus(err), fiber.Map{"error": err.Error()}
+	}
+	resized = true
+
+	slog.Info("Saving file", "fileid", fileID, "content_length", len(data), "finish", r.finish)
+	events.Publish(events.SaveRequested, sessionID, map[string]any{"fileid": fileID, "path": r.file.Path(), "size": len(data), "ip": r.ip})
 	// Save the file content to server
-	if err := os.WriteFile(fileInfo.Path(), []byte(fileSaveReq.Content), 0644); err != nil {
+	if err := r.file.Write(ctx, data); err != nil {
 		slog.Error("Failed to write file", "fileid", fileID, "err", err)
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to save file"})
+		saveFailed("failed to write file")
+		return fiber.StatusInternalServerError, fiber.Map{"error": "failed to save file"}
+	}
+	if r.raw == nil && !r.file.E2E() {
+		r.file.SetEncoding(r.encoding)
+		r.file.SetTextFormat(r.format)
 	}
 
 	// notify the client about the save
-	if err := hub.NotifySessionSave(fileSaveReq.Content); err != nil {
+	if err := r.hub.NotifySessionSave(r.file, data, r.finish); err != nil {
 		slog.Warn("Failed to notify session about file save", "fileid", fileID, "err", err)
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to notify client"})
+		saveFailed("failed to notify client")
+		return fiber.StatusInternalServerError, fiber.Map{"error": "failed to notify client"}
 	}
 
 	// wait for confirmation from the client
-	success, reason, err := hub.WaitSaveResult()
+	success, reason, err := r.hub.WaitSaveResult()
 	if err != nil {
 		slog.Error("Failed to get save confirmation from client", "fileid", fileID, "err", err)
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "save confirmation failed", "reason": err.Error()})
+		saveFailed(err.Error())
+		return fiber.StatusInternalServerError, fiber.Map{"error": "save confirmation failed", "reason": err.Error()}
 	}
 
 	if !success {
 		slog.Error("Client reported save failure", "fileid", fileID, "reason", reason)
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "client save failed", "reason": reason})
+		saveFailed(reason)
+		return fiber.StatusInternalServerError, fiber.Map{"error": "client save failed", "reason": reason}
 	}
 
-	return c.Status(fiber.StatusOK).JSON(fiber.Map{"message": "file saved successfully"})
+	events.Publish(events.SaveSucceeded, sessionID, map[string]any{"fileid": fileID, "path": r.file.Path(), "size": len(data)})
+	saveAudit.Result = "success"
+	audit.Record(saveAudit)
+	resp := fiber.Map{"message": "file saved successfully", "revision": r.file.Revision()}
+	if r.raw == nil && !r.file.E2E() {
+		resp["encoding"] = r.encoding
+		resp["format"] = r.format
+	}
+	return fiber.StatusOK, resp
 }
 
 func handleGetFile(c *fiber.Ctx) error {
@@ -197,33 +414,88 @@ func handleGetFile(c *fiber.Ctx) error {
 	if fileInfo == nil {
 		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "file not found"})
 	}
-	hub := hubManager.GetHub(fileInfo.ID())
+	hub := hubManager.GetHub(fileInfo.SessionID())
 	if hub == nil {
 		slog.Error("No editing hub found for file", "fileid", fileInfo.ID())
 		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "editing hub not found"})
 	}
-	content, err := os.ReadFile(fileInfo.Path())
+	resp := fiber.Map{
+		"fileid":     fileInfo.ID(),
+		"sessionid":  fileInfo.SessionID(),
+		"roomexists": hub.RoomClientCount(fileInfo.ID()) > 0,
+		"filename":   fileInfo.Name(),
+		"path":       fileInfo.Path(),
+		"revision":   fileInfo.Revision(),
+		"e2e":        fileInfo.E2E(),
+		"expires_at": sessionExpiresAt(c.Context(), fileInfo),
+	}
+	// 二进制文件不在 JSON 中返回内容, 浏览器通过 raw 或 hex 接口只读查看
+	if fileInfo.Binary() {
+		resp["binary"] = true
+		resp["readonly"] = true
+		resp["content_type"] = fileInfo.ContentType()
+		resp["size"] = fileInfo.Size()
+		resp["view"] = binaryView(fileInfo)
+		return c.Status(fiber.StatusOK).JSON(resp)
+	}
+	content, err := fileInfo.Read(c.Context())
 	if err != nil {
 		slog.Error("Failed to read file", "fileid", fileInfo.ID(), "err", err)
 		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to read file"})
 	}
-	return c.Status(fiber.StatusOK).JSON(fiber.Map{
-		"fileid":     fileInfo.ID(),
-		"content":    string(content),
-		"roomexists": !hub.IsEmpty(),
-		"filename":   fileInfo.Name(),
-		"language": func() string {
-			ext := filepath.Ext(fileInfo.Name())
-			if ext == "" {
-				return "plaintext"
-			}
-			ext = strings.TrimPrefix(ext, ".")
-			if lang, ok := extToLang[strings.ToLower(ext)]; ok {
-				return lang
-			}
-			return "plaintext"
-		}(),
-	})
+	text := string(content)
+	if !fileInfo.E2E() {
+		// 编辑器只处理 UTF-8, 原始编码随响应返回, 保存时再转换回去
+		if text, err = textenc.Decode(content, fileInfo.Encoding()); err != nil {
+			slog.Error("Failed to decode file", "fileid", fileInfo.ID(), "encoding", fileInfo.Encoding().Name, "err", err)
+			return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to decode file"})
+		}
+		// 大文件或者指定了行范围时只返回一页, 浏览器之后用 patches 保存修改的行
+		start, lines, paged, err := pageRequest(c, fileInfo)
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		}
+		resp["large"] = fileInfo.Size() > config.C().LargeFileSize()
+		if paged {
+			starts := lineStarts(text)
+			from, to := lineSpan(text, starts, start, lines)
+			text = text[from:to]
+			resp["range"] = fiber.Map{"start": start, "lines": len(lineStarts(text)), "total_lines": len(starts)}
+		}
+	}
+	resp["content"] = text
+	if props := fileInfo.EditorConfig(); props != nil {
+		resp["editorconfig"] = props
+	}
+	// e2e 会话的内容是密文, 由浏览器解密后自行识别语言
+	if !fileInfo.E2E() {
+		resp["language"] = detectLanguage(fileInfo.Name())
+		// 返回保存时实际使用的编码和格式, 浏览器显示的与保存结果一致
+		resp["encoding"], resp["format"] = fileEncoding(fileInfo), fileFormat(fileInfo)
+	}
+	return c.Status(fiber.StatusOK).JSON(resp)
+}
+
+// sessionExpiresAt 返回文件所属会话的到期时间, 它记录在会话的第一个文件上
+func sessionExpiresAt(ctx context.Context, f filestor.File) time.Time {
+	if f.ID() != f.SessionID() {
+		if primary := filestor.Get(ctx, f.SessionID()); primary != nil {
+			return primary.ExpiresAt()
+		}
+	}
+	return f.ExpiresAt()
+}
+
+func detectLanguage(filename string) string {
+	ext := filepath.Ext(filename)
+	if ext == "" {
+		return "plaintext"
+	}
+	ext = strings.TrimPrefix(ext, ".")
+	if lang, ok := extToLang[strings.ToLower(ext)]; ok {
+		return lang
+	}
+	return "plaintext"
 }
 
 func handleSessionWSUpgrade(c *fiber.Ctx) error {
@@ -237,11 +509,19 @@ func handleSessionWSUpgrade(c *fiber.Ctx) error {
 			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid sessionid format"})
 		}
 		fileInfo := filestor.Get(c.Context(), fileID.String())
-		if fileInfo == nil {
+		if fileInfo == nil || fileInfo.SessionID() != fileInfo.ID() {
 			return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "file not found"})
 		}
 		c.Locals("fileInfo", fileInfo)
-		slog.Info("WebSocket connection request", "sessionid", sessionid, "fileid", fileID)
+		// 客户端程序用逗号分隔的 capabilities 查询参数声明支持的协议扩展, 例如 "base64"
+		var capabilities []string
+		for _, v := range strings.Split(c.Query("capabilities"), ",") {
+			if v = strings.TrimSpace(v); v != "" {
+				capabilities = append(capabilities, utils.CopyString(v))
+			}
+		}
+		c.Locals("capabilities", capabilities)
+		slog.Info("WebSocket connection request", "sessionid", sessionid, "fileid", fileID, "capabilities", capabilities)
 		return c.Next()
 	}
 	return fiber.ErrUpgradeRequired
@@ -256,7 +536,8 @@ func handleSessionWSConn(conn *websocket.Conn) {
 	}
 
 	sessionID := fileInfo.ID()
-	hub, err := hubManager.CreateHub(sessionID, conn)
+	capabilities, _ := conn.Locals("capabilities").([]string)
+	hub, err := hubManager.CreateHub(sessionID, conn, capabilities)
 	if err != nil {
 		slog.Error("Failed to create editing hub for session", "sessionid", sessionID, "err", err)
 		conn.Close()
@@ -264,8 +545,10 @@ func handleSessionWSConn(conn *websocket.Conn) {
 	}
 
 	slog.Info("Session WebSocket connected", "sessionid", sessionID)
+	events.Publish(events.CLIConnected, sessionID, map[string]any{"addr": conn.RemoteAddr().String()})
 
 	defer func() {
+		events.Publish(events.CLIDisconnected, sessionID, nil)
 		hubManager.CleanupSession(sessionID)
 		slog.Info("Session WebSocket disconnected and cleaned up", "sessionid", sessionID)
 	}()
@@ -356,39 +639,148 @@ func handleSessionWSConn(conn *websocket.Conn) {
 				slog.Error("Client reported file save failure", "sessionid", sessionID, "reason", reason)
 			}
 		}
+		// 服务端代浏览器发出的 list_dir / open_file 请求的回复
+		if msgType, _ := msg["type"].(string); msgType == "list_dir_result" || msgType == "open_file_result" {
+			hub.resolveRequest(msg)
+		}
 	}
 }
 
+// handleCreateSession 创建会话, 请求体可以是 multipart 表单, JSON 或单个文件的原始内容,
+// 并且可以用 gzip 或 zstd 压缩, 见 parseSessionBody
 func handleCreateSession(c *fiber.Ctx) error {
-	file, err := c.FormFile("document")
+	if err := decodeContentEncoding(c); err != nil {
+		return sessionErrorResponse(c, err)
+	}
+	req, err := parseSessionBody(c)
 	if err != nil {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "file is required"})
-	}
-	if file.Size > config.MaxFileSize {
-		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "file size exceeds limit"})
-	}
-	fileID := uuid.New().String()
-	filePath := filepath.Join(config.C.UploadsDir, fileID, file.Filename)
-	if err := os.MkdirAll(filepath.Dir(filePath), 0755); err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to create directory"})
-	}
-
-	if err := c.SaveFile(file, filePath); err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to save file"})
-	}
-	slog.Info("File uploaded", "fileid", fileID, "filename", file.Filename, "size", file.Size)
-	if err := filestor.Save(c.Context(),
-		fileID,
-		filestor.NewFile(fileID,
-			filePath,
-			file.Filename,
-			filepath.Join(config.C.UploadsDir, fileID),
-		),
-	); err != nil {
-		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to save file info"})
-	}
-	return c.Status(fiber.StatusOK).JSON(fiber.Map{
-		"sessionid": fileID,
-		"editurl":   fmt.Sprintf("%s/edit/%s", config.C.ServerURLs[rand.Intn(len(config.C.ServerURLs))], fileID),
-	})
+		return sessionErrorResponse(c, err)
+	}
+	req.origin = filestor.Origin{IP: clientIP(c)}
+	if key := apiKeyFromCtx(c); key != nil {
+		req.key = key
+		req.origin.KeyID, req.origin.KeyName = key.ID, key.Name
+	}
+	resp, err := createSession(c.Context(), req)
+	if err != nil {
+		return sessionErrorResponse(c, err)
+	}
+	return c.Status(fiber.StatusOK).JSON(resp)
+}
+
+// handleExtendSession 供会话所有者凭 X-Owner-Token 延长会话, 不能超过最长生命周期
+func handleExtendSession(c *fiber.Ctx) error {
+	id := c.Params("sessionid")
+	fileInfo := filestor.Get(c.Context(), id)
+	if fileInfo == nil || fileInfo.SessionID() != id {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "session not found"})
+	}
+	if !fileInfo.IsOwner(c.Get("X-Owner-Token")) {
+		return c.Status(fiber.StatusForbidden).JSON(fiber.Map{"error": "invalid owner token"})
+	}
+	var req ExtendRequest
+	if len(c.Body()) > 0 {
+		if err := c.BodyParser(&req); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "invalid request body"})
+		}
+	}
+	ttl, err := parseTTL(req.TTL)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+	expiresAt := sessionExpiry(fileInfo.CreatedAt(), time.Now(), ttl)
+	if expiresAt.Before(fileInfo.ExpiresAt()) {
+		expiresAt = fileInfo.ExpiresAt()
+	}
+	fileInfo.SetExpiresAt(expiresAt)
+	slog.Info("Session extended", "sessionid", id, "expires_at", expiresAt)
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"sessionid": id, "expires_at": expiresAt})
+}
+
+// parseTTL 接受 Go duration ("90m") 或秒数, 为空时返回 0 表示使用最长生命周期
+func parseTTL(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	ttl, err := time.ParseDuration(s)
+	if err != nil {
+		secs, serr := strconv.Atoi(s)
+		if serr != nil {
+			return 0, fmt.Errorf("invalid ttl %q", s)
+		}
+		ttl = time.Duration(secs) * time.Second
+	}
+	if ttl <= 0 {
+		return 0, fmt.Errorf("ttl must be positive")
+	}
+	return ttl, nil
+}
+
+// sessionExpiry 返回 now+ttl, 但不超过 createdAt 加上 session_max_lifetime_hours
+func sessionExpiry(createdAt, now time.Time, ttl time.Duration) time.Time {
+	limit := createdAt.Add(time.Duration(config.C().SessionMaxLifetimeH) * time.Hour)
+	if ttl <= 0 || now.Add(ttl).After(limit) {
+		return limit
+	}
+	return now.Add(ttl)
+}
+
+func newOwnerToken() string {
+	b := make([]byte, 24)
+	if _, err := crand.Read(b); err != nil {
+		panic(fmt.Sprintf("crypto/rand failed: %v", err))
+	}
+	return hex.EncodeToString(b)
+}
+
+func quotaError(c *fiber.Ctx, err error) error {
+	return c.Status(quotaStatus(err)).JSON(fiber.Map{"error": err.Error()})
+}
+
+func quotaStatus(err error) int {
+	if quota.IsTooLarge(err) {
+		return fiber.StatusRequestEntityTooLarge
+	}
+	return fiber.StatusTooManyRequests
+}
+
+// clientIP 返回可以在请求结束后继续保存的客户端 IP
+func clientIP(c *fiber.Ctx) string {
+	if ip := c.IP(); ip != "" {
+		return utils.CopyString(ip)
+	}
+	return c.Context().RemoteIP().String()
+}
+
+// participantID 返回浏览器在 welcome 消息中得到并回传的参与者 ID.
+// 这个头由浏览器提供, 不是文件房间中已连接的参与者时加上 "unverified:" 前缀记录
+func participantID(c *fiber.Ctx, hub *EditingHub, room string) string {
+	id := c.Get("X-Participant-ID")
+	if id == "" {
+		return ""
+	}
+	if len(id) > 64 {
+		id = id[:64]
+	}
+	if !hub.HasParticipant(room, id) {
+		return "unverified:" + id
+	}
+	return utils.CopyString(id)
+}
+
+func formBool(c *fiber.Ctx, key string) (bool, error) {
+	v := c.FormValue(key)
+	if v == "" {
+		return false, nil
+	}
+	return strconv.ParseBool(v)
+}
+
+func readFormFile(file *multipart.FileHeader) ([]byte, error) {
+	f, err := file.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	return io.ReadAll(f)
 }
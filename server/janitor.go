@@ -0,0 +1,192 @@
+package server
+
+import (
+	"context"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"remdit-server/config"
+	"remdit-server/service/stors/filestor"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// JanitorReport 记录一次 uploads_dir 清理的结果
+type JanitorReport struct {
+	StartedAt      time.Time `json:"started_at"`
+	FinishedAt     time.Time `json:"finished_at"`
+	OrphansRemoved int       `json:"orphans_removed"`
+	Evicted        []string  `json:"evicted"`
+	ReclaimedBytes int64     `json:"reclaimed_bytes"`
+	UsageBytes     int64     `json:"usage_bytes"`
+	Errors         []string  `json:"errors,omitempty"`
+}
+
+// janitor 对照 FileInfoStorage 清理 uploads_dir 中没有会话的目录, 并执行磁盘预算
+type janitor struct {
+	mu   sync.Mutex
+	last atomic.Pointer[JanitorReport]
+}
+
+var uploadsJanitor = &janitor{}
+
+type sessionUsage struct {
+	id       string
+	size     int64
+	lastUsed time.Time
+}
+
+// Start 立即清理一次, 之后按 janitor_interval_minutes 周期运行, 只在本地存储时生效
+func (j *janitor) Start(ctx context.Context) {
+	if config.C().Storage != config.StorageLocal {
+		return
+	}
+	go func() {
+		for {
+			j.Run(ctx)
+			timer := time.NewTimer(time.Duration(config.C().JanitorIntervalMin) * time.Minute)
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case <-timer.C:
+			}
+		}
+	}()
+}
+
+func (j *janitor) Run(ctx context.Context) JanitorReport {
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	cfg := config.C()
+	report := JanitorReport{StartedAt: time.Now(), Evicted: make([]string, 0)}
+	fail := func(msg string, err error) {
+		slog.Error(msg, "err", err)
+		report.Errors = append(report.Errors, msg+": "+err.Error())
+	}
+
+	entries, err := os.ReadDir(cfg.UploadsDir)
+	if err != nil {
+		fail("Failed to read uploads_dir", err)
+	}
+	maxAge := time.Duration(cfg.OrphanMaxAgeMin) * time.Minute
+	hubs := hubManager.Hubs()
+	var live []sessionUsage
+	for _, entry := range entries {
+		name := entry.Name()
+		if !isSessionEntry(entry) {
+			continue
+		}
+		path := filepath.Join(cfg.UploadsDir, name)
+		size, modTime, err := diskUsage(path)
+		if err != nil {
+			fail("Failed to stat "+path, err)
+			continue
+		}
+		if f := filestor.Get(ctx, name); f != nil {
+			u := sessionUsage{id: name, size: size, lastUsed: f.CreatedAt()}
+			if hub := hubs[name]; hub != nil {
+				if !hub.IsEmpty() {
+					// 有浏览器在编辑的会话不会被驱逐
+					report.UsageBytes += size
+					continue
+				}
+				u.lastUsed = hub.LastActiveAt()
+			}
+			report.UsageBytes += size
+			live = append(live, u)
+			continue
+		}
+		// 刚上传的文件在登记到 FileInfoStorage 之前也没有对应的会话, 需要等待一段时间
+		if time.Since(modTime) < maxAge {
+			report.UsageBytes += size
+			continue
+		}
+		if err := os.RemoveAll(path); err != nil {
+			fail("Failed to remove orphaned upload "+path, err)
+			continue
+		}
+		slog.Info("Removed orphaned upload", "path", path, "size", size, "modified", modTime)
+		report.OrphansRemoved++
+		report.ReclaimedBytes += size
+	}
+
+	budget := int64(cfg.DiskBudgetMB) * 1024 * 1024
+	if budget > 0 && report.UsageBytes > budget {
+		sort.Slice(live, func(i, k int) bool { return live[i].lastUsed.Before(live[k].lastUsed) })
+		for _, u := range live {
+			if report.UsageBytes <= budget {
+				break
+			}
+			slog.Warn("Evicting idle session to stay within disk budget", "sessionid", u.id, "size", u.size, "idle", time.Since(u.lastUsed))
+			hubManager.CloseSession(u.id, "This session was closed because the server ran out of storage space", "evicted by disk budget")
+			report.Evicted = append(report.Evicted, u.id)
+			report.UsageBytes -= u.size
+			report.ReclaimedBytes += u.size
+		}
+		if report.UsageBytes > budget {
+			slog.Warn("Disk usage is still over budget", "usage_bytes", report.UsageBytes, "budget_bytes", budget)
+		}
+	}
+
+	report.FinishedAt = time.Now()
+	j.last.Store(&report)
+	slog.Info("Janitor finished",
+		"orphans_removed", report.OrphansRemoved,
+		"evicted", len(report.Evicted),
+		"reclaimed_bytes", report.ReclaimedBytes,
+		"usage_bytes", report.UsageBytes)
+	return report
+}
+
+// Last 返回最近一次清理的结果, 还未运行过时为 nil
+func (j *janitor) Last() *JanitorReport {
+	return j.last.Load()
+}
+
+// isSessionEntry 只处理会话目录和写入中断留下的临时文件, 其它文件保持不动
+func isSessionEntry(entry fs.DirEntry) bool {
+	if entry.IsDir() {
+		_, err := uuid.Parse(entry.Name())
+		return err == nil
+	}
+	return strings.HasPrefix(entry.Name(), ".tmp-") || strings.HasPrefix(entry.Name(), ".check-")
+}
+
+// diskUsage 返回 path 下所有文件的总大小和最近的修改时间
+func diskUsage(path string) (int64, time.Time, error) {
+	var size int64
+	var modTime time.Time
+	err := filepath.WalkDir(path, func(_ string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			size += info.Size()
+		}
+		if info.ModTime().After(modTime) {
+			modTime = info.ModTime()
+		}
+		return nil
+	})
+	return size, modTime, err
+}
+
+func handleAdminJanitor(c *fiber.Ctx) error {
+	report := uploadsJanitor.Last()
+	if report == nil {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "janitor has not run yet"})
+	}
+	return c.Status(fiber.StatusOK).JSON(report)
+}
@@ -0,0 +1,131 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"remdit-server/config"
+	"remdit-server/service/stors/filestor"
+	"remdit-server/service/textenc"
+	"sort"
+	"strconv"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+// 大文件模式下每页的默认行数和上限
+const (
+	defaultPageLines = 1000
+	maxPageLines     = 20000
+)
+
+// lineStarts 返回每一行在 text 中的起始位置, "\n", "\r\n" 和单独的 "\r" 都算作换行.
+// 以换行结尾时不计入最后的空行
+func lineStarts(text string) []int {
+	if text == "" {
+		return nil
+	}
+	starts := []int{0}
+	for i := 0; i < len(text); i++ {
+		switch text[i] {
+		case '\r':
+			if i+1 < len(text) && text[i+1] == '\n' {
+				i++
+			}
+			fallthrough
+		case '\n':
+			if i+1 < len(text) {
+				starts = append(starts, i+1)
+			}
+		}
+	}
+	return starts
+}
+
+// lineSpan 返回第 start 行 (从 1 开始) 起 n 行在 text 中的字节范围, 包括行尾的换行符
+func lineSpan(text string, starts []int, start, n int) (int, int) {
+	from := len(text)
+	if start-1 < len(starts) {
+		from = starts[start-1]
+	}
+	to := len(text)
+	if end := start - 1 + n; end < len(starts) {
+		to = starts[end]
+	}
+	return from, to
+}
+
+// pageRequest 解析 GET /api/file 的 start 和 lines 参数, 大文件没有指定时返回第一页
+func pageRequest(c *fiber.Ctx, f filestor.File) (start, lines int, paged bool, err error) {
+	qs, ql := c.Query("start"), c.Query("lines")
+	if qs == "" && ql == "" && f.Size() <= config.C().LargeFileSize() {
+		return 0, 0, false, nil
+	}
+	start, lines = 1, defaultPageLines
+	if qs != "" {
+		if start, err = strconv.Atoi(qs); err != nil || start < 1 {
+			return 0, 0, false, fmt.Errorf("start must be a positive line number")
+		}
+	}
+	if ql != "" {
+		if lines, err = strconv.Atoi(ql); err != nil || lines < 1 || lines > maxPageLines {
+			return 0, 0, false, fmt.Errorf("lines must be between 1 and %d", maxPageLines)
+		}
+	}
+	return start, lines, true, nil
+}
+
+// applyPatches 按行范围替换 text 中的内容. 每个 patch 的行号都相对于原始内容, 范围之间不能重叠
+func applyPatches(text string, patches []LinePatch) (string, error) {
+	sorted := append([]LinePatch{}, patches...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Start < sorted[j].Start })
+	starts := lineStarts(text)
+	total := len(starts)
+	var out []byte
+	pos := 0
+	for i, p := range sorted {
+		switch {
+		case p.Start < 1 || p.Lines < 0:
+			return "", fmt.Errorf("patch %d: start must be positive and lines must not be negative", i)
+		case p.Start+p.Lines-1 > total || p.Start > total+1:
+			return "", fmt.Errorf("patch %d: lines %d-%d are out of range, the file has %d lines", i, p.Start, p.Start+p.Lines-1, total)
+		case i > 0 && (p.Start == sorted[i-1].Start || p.Start < sorted[i-1].Start+sorted[i-1].Lines):
+			return "", fmt.Errorf("patch %d overlaps another patch", i)
+		}
+		from, to := lineSpan(text, starts, p.Start, p.Lines)
+		// 在末尾追加时, 原内容没有结尾换行需要先补上
+		if from == len(text) && text != "" && p.Content != "" && !endsWithNewline(text) {
+			out = append(out, text[pos:from]...)
+			out = append(out, '\n')
+			pos = from
+		}
+		out = append(out, text[pos:from]...)
+		out = append(out, p.Content...)
+		pos = to
+	}
+	out = append(out, text[pos:]...)
+	return string(out), nil
+}
+
+func endsWithNewline(text string) bool {
+	return text != "" && (text[len(text)-1] == '\n' || text[len(text)-1] == '\r')
+}
+
+// patchedContent 读取文件当前内容并应用 patches, 返回新的 UTF-8 文本
+func patchedContent(ctx context.Context, f filestor.File, patches []LinePatch) (string, int, fiber.Map) {
+	data, err := f.Read(ctx)
+	if err != nil {
+		slog.Error("Failed to read file", "fileid", f.ID(), "err", err)
+		return "", fiber.StatusInternalServerError, fiber.Map{"error": "failed to read file"}
+	}
+	text, err := textenc.Decode(data, f.Encoding())
+	if err != nil {
+		slog.Error("Failed to decode file", "fileid", f.ID(), "err", err)
+		return "", fiber.StatusInternalServerError, fiber.Map{"error": "failed to decode file"}
+	}
+	patched, err := applyPatches(text, patches)
+	if err != nil {
+		return "", fiber.StatusBadRequest, fiber.Map{"error": err.Error()}
+	}
+	return patched, 0, nil
+}
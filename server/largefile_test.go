@@ -0,0 +1,155 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+func TestLineStarts(t *testing.T) {
+	tests := []struct {
+		text string
+		want []int
+	}{
+		{"", nil},
+		{"a", []int{0}},
+		{"a\n", []int{0}},
+		{"a\nb", []int{0, 2}},
+		{"\n\n", []int{0, 1}},
+		{"a\r\nb\rc\n", []int{0, 3, 5}},
+		{"a\r\n\r\n", []int{0, 3}},
+	}
+	for _, tt := range tests {
+		if got := lineStarts(tt.text); !slices.Equal(got, tt.want) {
+			t.Errorf("lineStarts(%q) = %v, want %v", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestLineSpan(t *testing.T) {
+	text := "a\nbb\nc"
+	starts := lineStarts(text)
+	tests := []struct {
+		start, n int
+		want     string
+	}{
+		{1, 1, "a\n"},
+		{2, 1, "bb\n"},
+		{3, 1, "c"},
+		{1, 3, text},
+		{2, 5, "bb\nc"},
+		{2, 0, ""},
+		{4, 0, ""},
+		{9, 1, ""},
+	}
+	for _, tt := range tests {
+		from, to := lineSpan(text, starts, tt.start, tt.n)
+		if got := text[from:to]; got != tt.want {
+			t.Errorf("lineSpan(%d, %d) = %q, want %q", tt.start, tt.n, got, tt.want)
+		}
+	}
+}
+
+func TestApplyPatches(t *testing.T) {
+	tests := []struct {
+		name    string
+		text    string
+		patches []LinePatch
+		want    string
+		err     string
+	}{
+		{"replace", "a\nb\nc\n", []LinePatch{{Start: 2, Lines: 1, Content: "B\n"}}, "a\nB\nc\n", ""},
+		{"replace several lines", "a\nb\nc\n", []LinePatch{{Start: 1, Lines: 2, Content: "x\n"}}, "x\nc\n", ""},
+		{"delete", "a\nb\nc\n", []LinePatch{{Start: 2, Lines: 1}}, "a\nc\n", ""},
+		{"insert first", "a\nb\n", []LinePatch{{Start: 1, Content: "x\n"}}, "x\na\nb\n", ""},
+		{"append", "a\nb\n", []LinePatch{{Start: 3, Content: "c\n"}}, "a\nb\nc\n", ""},
+		{"append without final newline", "a\nb", []LinePatch{{Start: 3, Content: "c\n"}}, "a\nb\nc\n", ""},
+		{"replace last line without final newline", "a\nb", []LinePatch{{Start: 2, Lines: 1, Content: "B"}}, "a\nB", ""},
+		{"empty file", "", []LinePatch{{Start: 1, Content: "x\n"}}, "x\n", ""},
+		{"crlf", "a\r\nb\r\n", []LinePatch{{Start: 2, Lines: 1, Content: "B\r\n"}}, "a\r\nB\r\n", ""},
+		{
+			"unsorted patches use original line numbers", "a\nb\nc\n",
+			[]LinePatch{{Start: 3, Lines: 1, Content: "C\n"}, {Start: 1, Lines: 1, Content: "A\nA2\n"}},
+			"A\nA2\nb\nC\n", "",
+		},
+		{
+			"adjacent patches", "a\nb\nc\n",
+			[]LinePatch{{Start: 1, Lines: 1, Content: "A\n"}, {Start: 2, Lines: 1, Content: "B\n"}},
+			"A\nB\nc\n", "",
+		},
+		{
+			"insert after replaced line", "a\nb\n",
+			[]LinePatch{{Start: 1, Lines: 1, Content: "A\n"}, {Start: 2, Content: "x\n"}},
+			"A\nx\nb\n", "",
+		},
+		{
+			"overlap", "a\nb\nc\n",
+			[]LinePatch{{Start: 1, Lines: 2, Content: "x\n"}, {Start: 2, Lines: 1, Content: "y\n"}},
+			"", "overlaps",
+		},
+		{
+			"same start", "a\nb\n",
+			[]LinePatch{{Start: 2, Content: "x\n"}, {Start: 2, Lines: 1, Content: "y\n"}},
+			"", "overlaps",
+		},
+		{"past last line", "a\nb\n", []LinePatch{{Start: 2, Lines: 2}}, "", "out of range"},
+		{"insert past end", "a\nb\n", []LinePatch{{Start: 4}}, "", "out of range"},
+		{"zero start", "a\n", []LinePatch{{Start: 0, Lines: 1}}, "", "start must be positive"},
+		{"negative lines", "a\n", []LinePatch{{Start: 1, Lines: -1}}, "", "start must be positive"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := applyPatches(tt.text, tt.patches)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("err = %v, want %q", err, tt.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Fatalf("applyPatches = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPageRequest(t *testing.T) {
+	app := fiber.New()
+	app.Get("/", func(c *fiber.Ctx) error {
+		start, lines, paged, err := pageRequest(c, testFile(nil))
+		if err != nil {
+			return c.Status(fiber.StatusBadRequest).SendString(err.Error())
+		}
+		return c.SendString(fmt.Sprintf("%d %d %v", start, lines, paged))
+	})
+	tests := []struct {
+		query string
+		want  string
+	}{
+		{"start=5", "5 1000 true"},
+		{"lines=10", "1 10 true"},
+		{"start=3&lines=20000", "3 20000 true"},
+		{"start=0", "start must be a positive line number"},
+		{"start=x", "start must be a positive line number"},
+		{"lines=0", "lines must be between 1 and 20000"},
+		{"lines=20001", "lines must be between 1 and 20000"},
+	}
+	for _, tt := range tests {
+		resp, err := app.Test(httptest.NewRequest("GET", "/?"+tt.query, nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		if got := string(body); got != tt.want {
+			t.Errorf("pageRequest(%s) = %q, want %q", tt.query, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,56 @@
+package server
+
+import (
+	"fmt"
+	"log/slog"
+	"math"
+	"remdit-server/config"
+	"remdit-server/service/ratelimit"
+	"strconv"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+)
+
+var limitStore = ratelimit.NewStore()
+
+// rateLimit 按名为 policy 的限流策略限制请求, 策略在每次请求时从当前配置读取
+func rateLimit(policy string) fiber.Handler {
+	return func(c *fiber.Ctx) error {
+		p := config.C().RateLimit(policy)
+		res := limitStore.Take(policy+"|"+rateLimitKey(c, p.Key), ratelimit.Limit{
+			PerMinute: p.RPM,
+			Burst:     p.Burst,
+		})
+		c.Set("RateLimit-Policy", fmt.Sprintf("%d;w=60;burst=%d;policy=%q", p.RPM, p.Burst, policy))
+		c.Set("RateLimit-Limit", strconv.Itoa(res.Limit))
+		c.Set("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+		c.Set("RateLimit-Reset", strconv.Itoa(ceilSeconds(res.Reset)))
+		if !res.Allowed {
+			c.Set(fiber.HeaderRetryAfter, strconv.Itoa(ceilSeconds(res.RetryAfter)))
+			slog.Debug("Request rate limited", "policy", policy, "key", p.Key, "ip", c.IP(), "path", c.Path())
+			return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{"error": "rate limit exceeded"})
+		}
+		return c.Next()
+	}
+}
+
+func rateLimitKey(c *fiber.Ctx, by string) string {
+	switch by {
+	case config.RateLimitByAPIKey:
+		if key := apiKeyFromCtx(c); key != nil {
+			return "apikey:" + key.ID
+		}
+	case config.RateLimitByRoom:
+		for _, param := range []string{"fileid", "room", "sessionid", "uploadid"} {
+			if v := c.Params(param); v != "" {
+				return "room:" + v
+			}
+		}
+	}
+	return "ip:" + c.IP()
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
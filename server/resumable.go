@@ -0,0 +1,269 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"remdit-server/config"
+	"remdit-server/service/editorconfig"
+	"remdit-server/service/resumable"
+	"remdit-server/service/stors/filestor"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+)
+
+// 可续传上传实现 tus 1.0 的 core, creation, checksum, termination 和 expiration 扩展
+const (
+	tusVersion    = "1.0.0"
+	tusExtensions = "creation,checksum,termination,expiration"
+	// tus 约定的校验失败状态码
+	statusChecksumMismatch = 460
+)
+
+// tusMiddleware 为所有响应加上 Tus-Resumable, 并拒绝不支持的协议版本
+func tusMiddleware(c *fiber.Ctx) error {
+	c.Set("Tus-Resumable", tusVersion)
+	if c.Method() != fiber.MethodOptions && c.Get("Tus-Resumable") != tusVersion {
+		c.Set("Tus-Version", tusVersion)
+		return c.Status(fiber.StatusPreconditionFailed).JSON(fiber.Map{"error": "unsupported tus version"})
+	}
+	return c.Next()
+}
+
+func uploadExpiry() time.Duration {
+	return time.Duration(config.C().UploadExpiryMin) * time.Minute
+}
+
+// parseUploadMetadata 解析 Upload-Metadata: 以逗号分隔的 "key base64(value)"
+func parseUploadMetadata(header string) (map[string]string, error) {
+	meta := make(map[string]string)
+	for _, pair := range strings.Split(header, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		key, encoded, _ := strings.Cut(pair, " ")
+		value, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("invalid Upload-Metadata value for %q", key)
+		}
+		meta[key] = string(value)
+	}
+	return meta, nil
+}
+
+// parseChecksum 解析 "sha256 <base64>" 形式的校验和, 与 Upload-Checksum 头的格式相同
+func parseChecksum(s string) ([]byte, error) {
+	algo, encoded, ok := strings.Cut(strings.TrimSpace(s), " ")
+	if !ok || algo != "sha256" {
+		return nil, fmt.Errorf("checksum must use the sha256 algorithm")
+	}
+	sum, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(sum) != 32 {
+		return nil, fmt.Errorf("invalid sha256 checksum")
+	}
+	return sum, nil
+}
+
+// uploadSessionRequest 根据上传的元数据构造会话请求, 创建上传和完成上传时各调用一次.
+// 支持的元数据: filename 或 path, e2e, ttl, allow (逗号分隔) 和 editorconfig (JSON)
+func uploadSessionRequest(meta map[string]string) (sessionRequest, string, error) {
+	var req sessionRequest
+	p := meta["path"]
+	if p == "" {
+		p = meta["filename"]
+	}
+	if p == "" {
+		return req, "", fmt.Errorf("filename is required in Upload-Metadata")
+	}
+	if _, err := filestor.SanitizePath(p); err != nil {
+		return req, "", err
+	}
+	if v := meta["e2e"]; v != "" {
+		e2e, err := strconv.ParseBool(v)
+		if err != nil {
+			return req, "", fmt.Errorf("invalid e2e value")
+		}
+		req.e2e = e2e
+	}
+	ttl, err := parseTTL(meta["ttl"])
+	if err != nil {
+		return req, "", err
+	}
+	req.ttl = ttl
+	if v := meta["allow"]; v != "" {
+		req.allowed = strings.Split(v, ",")
+	}
+	if v := meta["editorconfig"]; v != "" {
+		var raw map[string]any
+		if err := json.Unmarshal([]byte(v), &raw); err != nil {
+			return req, "", fmt.Errorf("editorconfig must be a JSON object")
+		}
+		props, err := editorConfigProps(raw)
+		if err != nil {
+			return req, "", err
+		}
+		req.properties = []editorconfig.Properties{props}
+	}
+	return req, p, nil
+}
+
+func handleUploadOptions(c *fiber.Ctx) error {
+	c.Set("Tus-Version", tusVersion)
+	c.Set("Tus-Extension", tusExtensions)
+	c.Set("Tus-Max-Size", strconv.FormatInt(config.C().MaxFileSize(), 10))
+	c.Set("Tus-Checksum-Algorithm", "sha256")
+	return c.SendStatus(fiber.StatusNoContent)
+}
+
+// handleCreateUpload 创建可续传上传. 整个文件的校验和通过 checksum 元数据给出, 完成时校验
+func handleCreateUpload(c *fiber.Ctx) error {
+	length, err := strconv.ParseInt(c.Get("Upload-Length"), 10, 64)
+	if err != nil || length < 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Upload-Length is required"})
+	}
+	if length > config.C().MaxFileSize() {
+		return c.Status(fiber.StatusRequestEntityTooLarge).JSON(fiber.Map{"error": "file size exceeds limit"})
+	}
+	// 元数据在请求结束后仍然保存, 不能引用 fiber 复用的缓冲区
+	meta, err := parseUploadMetadata(utils.CopyString(c.Get("Upload-Metadata")))
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+	checksum, err := parseChecksum(meta["checksum"])
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "checksum metadata is required: " + err.Error()})
+	}
+	if _, _, err := uploadSessionRequest(meta); err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+	owner, client := "", "ip:"+clientIP(c)
+	if key := apiKeyFromCtx(c); key != nil {
+		owner, client = key.ID, "apikey:"+key.ID
+	}
+	u, err := resumable.Default().Create(length, checksum, meta, owner, client, config.C().MaxPendingUploads, uploadExpiry())
+	if err != nil {
+		slog.Warn("Resumable upload rejected", "client", client, "err", err)
+		return c.Status(fiber.StatusTooManyRequests).JSON(fiber.Map{"error": err.Error()})
+	}
+	location := "/api/uploads/" + u.ID
+	slog.Info("Resumable upload created", "uploadid", u.ID, "length", length, "ip", clientIP(c))
+	c.Set(fiber.HeaderLocation, location)
+	c.Set("Upload-Expires", u.ExpiresAt().UTC().Format(http.TimeFormat))
+	return c.Status(fiber.StatusCreated).JSON(fiber.Map{"uploadid": u.ID, "location": location, "expires_at": u.ExpiresAt()})
+}
+
+// uploadFromCtx 返回路径中的上传, 上传由 API key 创建时只有同一个 key 可以访问
+func uploadFromCtx(c *fiber.Ctx) (*resumable.Upload, int, string) {
+	u := resumable.Default().Get(c.Params("uploadid"))
+	if u == nil {
+		return nil, fiber.StatusNotFound, "upload not found"
+	}
+	if u.Owner != "" {
+		if key := apiKeyFromCtx(c); key == nil || key.ID != u.Owner {
+			return nil, fiber.StatusForbidden, "upload belongs to another api key"
+		}
+	}
+	return u, 0, ""
+}
+
+func setUploadHeaders(c *fiber.Ctx, u *resumable.Upload, offset int64) {
+	c.Set("Upload-Offset", strconv.FormatInt(offset, 10))
+	c.Set("Upload-Length", strconv.FormatInt(u.Length, 10))
+	c.Set("Upload-Expires", u.ExpiresAt().UTC().Format(http.TimeFormat))
+	c.Set(fiber.HeaderCacheControl, "no-store")
+}
+
+// handleUploadStatus 返回已经接收的字节数, 客户端从这里继续上传
+func handleUploadStatus(c *fiber.Ctx) error {
+	u, status, msg := uploadFromCtx(c)
+	if u == nil {
+		return c.Status(status).JSON(fiber.Map{"error": msg})
+	}
+	setUploadHeaders(c, u, u.Offset())
+	return c.SendStatus(fiber.StatusOK)
+}
+
+// handlePatchUpload 在 Upload-Offset 处追加一个分块. 最后一个分块到达后校验整个文件,
+// 通过后创建会话并返回与 POST /api/session 相同的内容
+func handlePatchUpload(c *fiber.Ctx) error {
+	u, status, msg := uploadFromCtx(c)
+	if u == nil {
+		return c.Status(status).JSON(fiber.Map{"error": msg})
+	}
+	if c.Get(fiber.HeaderContentType) != "application/offset+octet-stream" {
+		return c.Status(fiber.StatusUnsupportedMediaType).JSON(fiber.Map{"error": "Content-Type must be application/offset+octet-stream"})
+	}
+	offset, err := strconv.ParseInt(c.Get("Upload-Offset"), 10, 64)
+	if err != nil || offset < 0 {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": "Upload-Offset is required"})
+	}
+	var chunkSum []byte
+	if h := c.Get("Upload-Checksum"); h != "" {
+		if chunkSum, err = parseChecksum(h); err != nil {
+			return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+		}
+	}
+
+	newOffset, err := resumable.Default().Append(c.Context(), u, offset, c.Body(), chunkSum, uploadExpiry())
+	setUploadHeaders(c, u, newOffset)
+	switch {
+	case errors.Is(err, resumable.ErrOffsetMismatch):
+		return c.Status(fiber.StatusConflict).JSON(fiber.Map{"error": err.Error(), "offset": newOffset})
+	case errors.Is(err, resumable.ErrTooLarge):
+		return c.Status(fiber.StatusRequestEntityTooLarge).JSON(fiber.Map{"error": err.Error()})
+	case errors.Is(err, resumable.ErrChecksum):
+		return c.Status(statusChecksumMismatch).JSON(fiber.Map{"error": "chunk " + err.Error()})
+	case errors.Is(err, resumable.ErrGone):
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "upload not found"})
+	case err != nil:
+		slog.Error("Failed to store upload chunk", "uploadid", u.ID, "offset", offset, "err", err)
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to store chunk"})
+	}
+	if newOffset < u.Length {
+		return c.SendStatus(fiber.StatusNoContent)
+	}
+
+	content, err := resumable.Default().Complete(c.Context(), u)
+	if err != nil {
+		slog.Warn("Resumable upload failed verification", "uploadid", u.ID, "err", err)
+		if errors.Is(err, resumable.ErrChecksum) {
+			return c.Status(statusChecksumMismatch).JSON(fiber.Map{"error": "file checksum mismatch, the upload was discarded"})
+		}
+		return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": "failed to assemble upload"})
+	}
+	req, p, err := uploadSessionRequest(u.Metadata)
+	if err != nil {
+		return c.Status(fiber.StatusBadRequest).JSON(fiber.Map{"error": err.Error()})
+	}
+	req.uploads = []upload{{path: p, content: content}}
+	req.origin = filestor.Origin{IP: clientIP(c)}
+	if key := apiKeyFromCtx(c); key != nil {
+		req.key = key
+		req.origin.KeyID, req.origin.KeyName = key.ID, key.Name
+	}
+	resp, err := createSession(c.Context(), req)
+	if err != nil {
+		return sessionErrorResponse(c, err)
+	}
+	slog.Info("Resumable upload completed", "uploadid", u.ID, "sessionid", resp["sessionid"], "length", u.Length)
+	return c.Status(fiber.StatusOK).JSON(resp)
+}
+
+// handleDeleteUpload 取消上传并删除已接收的分块
+func handleDeleteUpload(c *fiber.Ctx) error {
+	u, status, msg := uploadFromCtx(c)
+	if u == nil {
+		return c.Status(status).JSON(fiber.Map{"error": msg})
+	}
+	resumable.Default().Remove(u)
+	slog.Info("Resumable upload cancelled", "uploadid", u.ID)
+	return c.SendStatus(fiber.StatusNoContent)
+}
@@ -0,0 +1,296 @@
+package server
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math/rand"
+	"path"
+	"remdit-server/config"
+	"remdit-server/service/apikey"
+	"remdit-server/service/audit"
+	"remdit-server/service/crypt"
+	"remdit-server/service/editorconfig"
+	"remdit-server/service/events"
+	"remdit-server/service/quota"
+	"remdit-server/service/stors/blobstor"
+	"remdit-server/service/stors/filestor"
+	"remdit-server/service/textenc"
+	"remdit-server/service/textfmt"
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/google/uuid"
+)
+
+// upload 是创建会话时上传的一个文件
+type upload struct {
+	path    string
+	content []byte
+}
+
+// sessionRequest 描述要创建的会话, 第一个文件的 ID 会作为会话 ID
+type sessionRequest struct {
+	uploads []upload
+	allowed []string // 允许浏览器通过客户端程序浏览和打开的路径模式
+	e2e     bool
+	ttl     time.Duration
+	origin  filestor.Origin
+	key     *apikey.Key
+	// editorconfig 是附带的 .editorconfig 文件, properties 是客户端程序解析好的属性,
+	// 只有一项时作用于所有文件, 否则与 uploads 一一对应
+	editorconfig []editorconfig.Source
+	properties   []editorconfig.Properties
+}
+
+// sessionError 是创建会话失败时返回给调用方的状态码和错误
+type sessionError struct {
+	status int
+	msg    string
+}
+
+func (e *sessionError) Error() string {
+	return e.msg
+}
+
+// SessionFile 是会话中的一个文件
+type SessionFile struct {
+	FileID     string `json:"fileid"`
+	Path       string `json:"path"`
+	Size       int64  `json:"size"`
+	Revision   int64  `json:"revision"`
+	RoomExists bool   `json:"roomexists"`
+	Binary     bool   `json:"binary,omitempty"`
+	EditURL    string `json:"editurl,omitempty"`
+}
+
+// cleanSessionPath 把路径模式和发给客户端程序的路径规范为以 '/' 分隔且不能跳出会话目录的形式
+func cleanSessionPath(p string) (string, error) {
+	p = strings.ReplaceAll(p, "\\", "/")
+	if p == "" || strings.ContainsRune(p, 0) {
+		return "", fmt.Errorf("invalid file path %q", p)
+	}
+	if strings.HasPrefix(p, "/") {
+		return "", fmt.Errorf("file path %q must be relative", p)
+	}
+	cleaned := path.Clean(p)
+	if cleaned == "." || cleaned == ".." || strings.HasPrefix(cleaned, "../") {
+		return "", fmt.Errorf("file path %q escapes the session", p)
+	}
+	return cleaned, nil
+}
+
+func editURL(fileID string) string {
+	urls := config.C().ServerURLs
+	return fmt.Sprintf("%s/edit/%s", urls[rand.Intn(len(urls))], fileID)
+}
+
+// createSession 保存上传的文件并登记会话, 成功时返回响应内容
+func createSession(ctx context.Context, req sessionRequest) (fiber.Map, error) {
+	if len(req.uploads) == 0 {
+		return nil, &sessionError{fiber.StatusBadRequest, "file is required"}
+	}
+	if len(req.uploads) > config.MaxSessionFiles {
+		return nil, &sessionError{fiber.StatusBadRequest, fmt.Sprintf("a session can contain at most %d files", config.MaxSessionFiles)}
+	}
+	for i, pattern := range req.allowed {
+		p, err := cleanSessionPath(pattern)
+		if err != nil {
+			return nil, &sessionError{fiber.StatusBadRequest, "invalid allow pattern: " + err.Error()}
+		}
+		if _, err := path.Match(p, ""); err != nil {
+			return nil, &sessionError{fiber.StatusBadRequest, fmt.Sprintf("invalid allow pattern %q", pattern)}
+		}
+		req.allowed[i] = p
+	}
+	if n := len(req.properties); n > 1 && n != len(req.uploads) {
+		return nil, &sessionError{fiber.StatusBadRequest, "editorconfig must be given once or once for every document"}
+	}
+	var total, largest int64
+	seen := make(map[string]bool, len(req.uploads))
+	contentOpts := make([][]filestor.Option, len(req.uploads))
+	for i, u := range req.uploads {
+		p, err := filestor.SanitizePath(u.path)
+		if err != nil {
+			return nil, &sessionError{fiber.StatusBadRequest, err.Error()}
+		}
+		if seen[p] {
+			return nil, &sessionError{fiber.StatusBadRequest, fmt.Sprintf("duplicate file path %q", p)}
+		}
+		seen[p] = true
+		req.uploads[i].path = p
+		size := int64(len(u.content))
+		if size > config.C().MaxFileSize() {
+			return nil, &sessionError{fiber.StatusBadRequest, "file size exceeds limit"}
+		}
+		total += size
+		largest = max(largest, size)
+		// e2e 的密文无法识别内容, 由浏览器自行处理
+		if !req.e2e {
+			if contentOpts[i], err = contentOptions(u.content); err != nil {
+				return nil, &sessionError{fiber.StatusUnsupportedMediaType, fmt.Sprintf("%s: %s", p, err)}
+			}
+		}
+	}
+
+	sessionID := uuid.New().String()
+	if req.key != nil {
+		if err := quota.Acquire(req.key, sessionID, total, largest); err != nil {
+			slog.Warn("Session rejected by key quota", "key", req.key.Name, "err", err)
+			return nil, &sessionError{quotaStatus(err), err.Error()}
+		}
+	}
+	now := time.Now()
+	expiresAt := sessionExpiry(now, now, req.ttl)
+	ownerToken := newOwnerToken()
+
+	files := make([]filestor.File, 0, len(req.uploads))
+	for i, u := range req.uploads {
+		fileID := sessionID
+		if i > 0 {
+			fileID = uuid.New().String()
+		}
+		var opts []filestor.Option
+		if i == 0 {
+			opts = append(opts,
+				filestor.WithExpiry(expiresAt),
+				filestor.WithOwnerToken(ownerToken),
+				filestor.WithAllowedPaths(req.allowed),
+			)
+		}
+		opts = append(opts, contentOpts[i]...)
+		var explicit editorconfig.Properties
+		switch len(req.properties) {
+		case 0:
+		case 1:
+			explicit = req.properties[0]
+		default:
+			explicit = req.properties[i]
+		}
+		var sources []editorconfig.Source
+		if i == 0 {
+			sources = req.editorconfig
+		}
+		opts = append(opts, filestor.WithEditorConfig(resolveEditorConfig(req.editorconfig, explicit, u.path), sources))
+		f := newSessionFile(sessionID, fileID, u.path, req.origin, req.e2e, opts...)
+		if err := f.Write(ctx, u.content); err != nil {
+			slog.Error("Failed to store file", "fileid", fileID, "err", err)
+			discardSession(sessionID, files)
+			f.Remove()
+			return nil, &sessionError{fiber.StatusInternalServerError, "failed to save file"}
+		}
+		files = append(files, f)
+	}
+	for _, f := range files {
+		if err := filestor.Save(ctx, f.ID(), f); err != nil {
+			discardSession(sessionID, files)
+			return nil, &sessionError{fiber.StatusInternalServerError, "failed to save file info"}
+		}
+	}
+
+	list := make([]SessionFile, 0, len(files))
+	for i, f := range files {
+		u := req.uploads[i]
+		slog.Info("File uploaded", "sessionid", sessionID, "fileid", f.ID(), "path", u.path, "size", len(u.content), "key", req.origin.KeyName, "e2e", req.e2e)
+		digest := sha256.Sum256(u.content)
+		audit.Record(audit.Entry{
+			Action:    audit.SessionCreated,
+			SessionID: sessionID,
+			IP:        req.origin.IP,
+			KeyName:   req.origin.KeyName,
+			Filename:  u.path,
+			Size:      int64(len(u.content)),
+			SHA256:    hex.EncodeToString(digest[:]),
+		})
+		list = append(list, SessionFile{FileID: f.ID(), Path: f.Path(), Size: f.Size(), Revision: f.Revision(), Binary: f.Binary(), EditURL: editURL(f.ID())})
+	}
+	events.Publish(events.SessionCreated, sessionID, map[string]any{
+		"filename": files[0].Path(),
+		"files":    len(files),
+		"size":     total,
+		"key":      req.origin.KeyName,
+		"ip":       req.origin.IP,
+		"e2e":      req.e2e,
+	})
+	return fiber.Map{
+		"sessionid":   sessionID,
+		"e2e":         req.e2e,
+		"expires_at":  expiresAt,
+		"owner_token": ownerToken,
+		"editurl":     list[0].EditURL,
+		"files":       list,
+	}, nil
+}
+
+// newSessionFile 创建会话中的一个文件, 内容需要通过 Write 写入.
+// 内容保存在由服务端生成的 <sessionid>/<fileid> 下, p 只作为经过 SanitizePath 处理的元数据
+func newSessionFile(sessionID, fileID, p string, origin filestor.Origin, e2e bool, extra ...filestor.Option) filestor.File {
+	opts := []filestor.Option{
+		filestor.WithSession(sessionID, p),
+		filestor.WithEncryption(crypt.Default()),
+	}
+	if e2e {
+		opts = append(opts, filestor.WithE2E())
+	}
+	opts = append(opts, extra...)
+	return filestor.NewFile(blobstor.Default(), fileID, sessionID+"/"+fileID, path.Base(p), origin, opts...)
+}
+
+var errBinaryRejected = errors.New("binary files are not accepted")
+
+// contentOptions 识别内容是否为二进制数据, 文本则识别字符编码和文本格式.
+// binary_files = "reject" 时二进制数据返回 errBinaryRejected
+func contentOptions(content []byte) ([]filestor.Option, error) {
+	if binary, ct := textenc.Sniff(content); binary {
+		if config.C().BinaryFiles == config.BinaryReject {
+			return nil, errBinaryRejected
+		}
+		return []filestor.Option{filestor.WithBinary(ct)}, nil
+	}
+	enc := textenc.Detect(content)
+	opts := []filestor.Option{filestor.WithTextEncoding(enc)}
+	if text, err := textenc.Decode(content, enc); err == nil {
+		opts = append(opts, filestor.WithTextFormat(textfmt.Analyze(text)))
+	}
+	return opts, nil
+}
+
+// discardSession 撤销创建到一半的会话
+func discardSession(sessionID string, files []filestor.File) {
+	quota.Release(sessionID)
+	for _, f := range files {
+		filestor.Delete(context.Background(), f.ID())
+		f.Remove()
+	}
+}
+
+func sessionErrorResponse(c *fiber.Ctx, err error) error {
+	var se *sessionError
+	if errors.As(err, &se) {
+		return c.Status(se.status).JSON(fiber.Map{"error": se.msg})
+	}
+	return c.Status(fiber.StatusInternalServerError).JSON(fiber.Map{"error": err.Error()})
+}
+
+// handleListSessionFiles 返回会话中的文件列表
+func handleListSessionFiles(c *fiber.Ctx) error {
+	id := c.Params("sessionid")
+	files := filestor.ListSession(c.Context(), id)
+	if len(files) == 0 {
+		return c.Status(fiber.StatusNotFound).JSON(fiber.Map{"error": "session not found"})
+	}
+	hub := hubManager.GetHub(id)
+	list := make([]SessionFile, 0, len(files))
+	for _, f := range files {
+		sf := SessionFile{FileID: f.ID(), Path: f.Path(), Size: f.Size(), Revision: f.Revision(), Binary: f.Binary()}
+		if hub != nil {
+			sf.RoomExists = hub.RoomClientCount(f.ID()) > 0
+		}
+		list = append(list, sf)
+	}
+	return c.Status(fiber.StatusOK).JSON(fiber.Map{"sessionid": id, "files": list})
+}
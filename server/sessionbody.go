@@ -0,0 +1,220 @@
+package server
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/url"
+	"remdit-server/config"
+	"remdit-server/service/editorconfig"
+	"strconv"
+	"strings"
+
+	"github.com/gofiber/fiber/v2"
+	"github.com/gofiber/fiber/v2/utils"
+	"github.com/klauspost/compress/zstd"
+)
+
+var errDecodedTooLarge = errors.New("decoded body exceeds limit")
+
+// decodeContentEncoding 按 Content-Encoding 解压请求体并替换原来的请求体, 支持 gzip 和 zstd.
+// 解压后的大小同样受 body_limit_mb 限制
+func decodeContentEncoding(c *fiber.Ctx) error {
+	header := c.Get(fiber.HeaderContentEncoding)
+	if header == "" {
+		return nil
+	}
+	// 不使用 c.Body(), 它会自动解压 gzip 但不限制解压后的大小
+	body := c.Request().Body()
+	codings := strings.Split(header, ",")
+	// 多个编码按应用顺序列出, 解码时倒序进行
+	for i := len(codings) - 1; i >= 0; i-- {
+		coding := strings.ToLower(strings.TrimSpace(codings[i]))
+		var err error
+		switch coding {
+		case "", "identity":
+			continue
+		case "gzip", "x-gzip":
+			body, err = gunzip(body)
+		case "zstd":
+			body, err = unzstd(body)
+		default:
+			return &sessionError{fiber.StatusUnsupportedMediaType, fmt.Sprintf("unsupported Content-Encoding %q", coding)}
+		}
+		if errors.Is(err, errDecodedTooLarge) {
+			return &sessionError{fiber.StatusRequestEntityTooLarge, "request body exceeds limit after decoding"}
+		}
+		if err != nil {
+			return &sessionError{fiber.StatusBadRequest, fmt.Sprintf("failed to decode %s body", coding)}
+		}
+	}
+	c.Request().SetBodyRaw(body)
+	c.Request().Header.Del(fiber.HeaderContentEncoding)
+	return nil
+}
+
+func gunzip(data []byte) ([]byte, error) {
+	r, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return readDecoded(r)
+}
+
+func unzstd(data []byte) ([]byte, error) {
+	r, err := zstd.NewReader(bytes.NewReader(data), zstd.WithDecoderConcurrency(1))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return readDecoded(r)
+}
+
+func readDecoded(r io.Reader) ([]byte, error) {
+	limit := int64(config.C().BodyLimit())
+	data, err := io.ReadAll(io.LimitReader(r, limit+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(data)) > limit {
+		return nil, errDecodedTooLarge
+	}
+	return data, nil
+}
+
+// parseSessionBody 按 Content-Type 解析创建会话的请求: multipart 表单, JSON,
+// 其他类型都把请求体作为单个文件的原始内容
+func parseSessionBody(c *fiber.Ctx) (sessionRequest, error) {
+	mediaType, _, _ := mime.ParseMediaType(c.Get(fiber.HeaderContentType))
+	switch mediaType {
+	case fiber.MIMEMultipartForm:
+		return formSessionRequest(c)
+	case fiber.MIMEApplicationJSON:
+		return jsonSessionRequest(c)
+	}
+	return rawSessionRequest(c)
+}
+
+// formSessionRequest 接受一个或多个 document 文件, 可以用同样数量的 path 字段指定各文件在会话中的相对路径
+func formSessionRequest(c *fiber.Ctx) (sessionRequest, error) {
+	var req sessionRequest
+	form, err := c.MultipartForm()
+	if err != nil || len(form.File["document"]) == 0 {
+		return req, &sessionError{fiber.StatusBadRequest, "file is required"}
+	}
+	docs, paths := form.File["document"], form.Value["path"]
+	if len(paths) > 0 && len(paths) != len(docs) {
+		return req, &sessionError{fiber.StatusBadRequest, "path must be given once for every document"}
+	}
+	// e2e 会话中上传和保存的内容都是浏览器端加密的密文, 密钥只存在于编辑链接的 fragment 中
+	if req.e2e, err = formBool(c, "e2e"); err != nil {
+		return req, &sessionError{fiber.StatusBadRequest, "invalid e2e value"}
+	}
+	if req.ttl, err = parseTTL(c.FormValue("ttl")); err != nil {
+		return req, &sessionError{fiber.StatusBadRequest, err.Error()}
+	}
+	if req.editorconfig, req.properties, err = parseEditorConfigForm(form); err != nil {
+		return req, &sessionError{fiber.StatusBadRequest, err.Error()}
+	}
+	req.allowed = form.Value["allow"]
+	for i, file := range docs {
+		if file.Size > config.C().MaxFileSize() {
+			return req, &sessionError{fiber.StatusBadRequest, "file size exceeds limit"}
+		}
+		content, err := readFormFile(file)
+		if err != nil {
+			return req, &sessionError{fiber.StatusBadRequest, "failed to read file"}
+		}
+		p := file.Filename
+		if len(paths) > 0 {
+			p = paths[i]
+		}
+		req.uploads = append(req.uploads, upload{path: p, content: content})
+	}
+	return req, nil
+}
+
+// jsonSessionRequest 解析 CreateSessionRequest
+func jsonSessionRequest(c *fiber.Ctx) (sessionRequest, error) {
+	var req sessionRequest
+	var body CreateSessionRequest
+	if err := c.BodyParser(&body); err != nil {
+		return req, &sessionError{fiber.StatusBadRequest, "invalid request body"}
+	}
+	ttl, err := parseTTL(body.TTL)
+	if err != nil {
+		return req, &sessionError{fiber.StatusBadRequest, err.Error()}
+	}
+	req.e2e, req.ttl, req.allowed = body.E2E, ttl, body.Allow
+	for i, f := range body.Files {
+		content, err := base64.StdEncoding.DecodeString(f.Content)
+		if err != nil {
+			return req, &sessionError{fiber.StatusBadRequest, fmt.Sprintf("files[%d]: content must be base64 encoded", i)}
+		}
+		req.uploads = append(req.uploads, upload{path: f.Path, content: content})
+	}
+	if len(body.EditorConfigFiles) > config.MaxSessionFiles {
+		return req, &sessionError{fiber.StatusBadRequest, fmt.Sprintf("at most %d editorconfig files are allowed", config.MaxSessionFiles)}
+	}
+	for i, f := range body.EditorConfigFiles {
+		data, err := base64.StdEncoding.DecodeString(f.Content)
+		if err != nil {
+			return req, &sessionError{fiber.StatusBadRequest, fmt.Sprintf("editorconfig_files[%d]: content must be base64 encoded", i)}
+		}
+		src, err := editorConfigSource(f.Path, data)
+		if err != nil {
+			return req, &sessionError{fiber.StatusBadRequest, err.Error()}
+		}
+		req.editorconfig = append(req.editorconfig, src)
+	}
+	if body.EditorConfig != nil {
+		props, err := editorConfigProps(body.EditorConfig)
+		if err != nil {
+			return req, &sessionError{fiber.StatusBadRequest, err.Error()}
+		}
+		req.properties = []editorconfig.Properties{props}
+	}
+	return req, nil
+}
+
+// rawSessionRequest 把整个请求体作为一个文件. 路径由 path 或 filename 查询参数给出,
+// 也可以用 X-Filename 头 (非 ASCII 字符需要 URL 编码); e2e, ttl 和 allow 通过查询参数给出
+func rawSessionRequest(c *fiber.Ctx) (sessionRequest, error) {
+	var req sessionRequest
+	p := c.Query("path", c.Query("filename"))
+	if p == "" {
+		var err error
+		if p, err = url.PathUnescape(c.Get("X-Filename")); err != nil {
+			return req, &sessionError{fiber.StatusBadRequest, "invalid X-Filename header"}
+		}
+	}
+	if p == "" {
+		if len(c.Body()) == 0 {
+			return req, &sessionError{fiber.StatusBadRequest, "file is required"}
+		}
+		return req, &sessionError{fiber.StatusBadRequest, "filename is required, use the X-Filename header or the filename query parameter"}
+	}
+	if v := c.Query("e2e"); v != "" {
+		e2e, err := strconv.ParseBool(v)
+		if err != nil {
+			return req, &sessionError{fiber.StatusBadRequest, "invalid e2e value"}
+		}
+		req.e2e = e2e
+	}
+	ttl, err := parseTTL(c.Query("ttl"))
+	if err != nil {
+		return req, &sessionError{fiber.StatusBadRequest, err.Error()}
+	}
+	req.ttl = ttl
+	for _, v := range c.Context().QueryArgs().PeekMulti("allow") {
+		req.allowed = append(req.allowed, string(v))
+	}
+	// 请求体和查询参数引用 fiber 复用的缓冲区, 会话保存的内容需要复制
+	req.uploads = []upload{{path: utils.CopyString(p), content: utils.CopyBytes(c.Body())}}
+	return req, nil
+}
@@ -1,7 +1,32 @@
 package server
 
+import (
+	"remdit-server/service/stors/filestor"
+	"time"
+)
+
 type FileSaveRequest struct {
 	Content string `json:"content" binding:"required"`
+	// Revision 是编辑所基于的版本, 不为 0 且与当前版本不同时拒绝保存
+	Revision int64 `json:"revision"`
+	// Encoding 和 BOM 覆盖文件原来的字符编码, 优先于 EditorConfig, 为空时沿用 EditorConfig 或上传时识别的编码
+	Encoding string `json:"encoding"`
+	BOM      *bool  `json:"bom"`
+	// LineEnding, FinalNewline, Indent 和 IndentSize 覆盖文件原来的格式, 优先于 EditorConfig, 为空时按 EditorConfig 或原格式规范化
+	LineEnding   string `json:"line_ending"`
+	FinalNewline *bool  `json:"final_newline"`
+	Indent       string `json:"indent"`
+	IndentSize   int    `json:"indent_size"`
+	// Patches 不为空时只替换其中的行范围, 忽略 Content, 此时必须给出 Revision
+	Patches []LinePatch `json:"patches"`
+}
+
+// LinePatch 把从第 Start 行 (从 1 开始) 起的 Lines 行替换为 Content, Lines 为 0 时在 Start 行前插入.
+// Content 应包含行尾的换行符
+type LinePatch struct {
+	Start   int    `json:"start"`
+	Lines   int    `json:"lines"`
+	Content string `json:"content"`
 }
 
 type SaveResultMessage struct {
@@ -14,3 +39,72 @@ type SaveResult struct {
 	Success bool
 	Reason  string
 }
+
+type NoticeMessage struct {
+	Type    string `json:"type"`
+	Level   string `json:"level"`
+	Message string `json:"message"`
+}
+
+type SessionInfo struct {
+	ID           string          `json:"sessionid"`
+	Filename     string          `json:"filename"`
+	Size         int64           `json:"size"`
+	CreatedAt    time.Time       `json:"created_at"`
+	LastActiveAt time.Time       `json:"last_active_at"`
+	Browsers     int             `json:"browsers"`
+	CLIConnected bool            `json:"cli_connected"`
+	Origin       filestor.Origin `json:"origin"`
+	E2E          bool            `json:"e2e"`
+	ExpiresAt    time.Time       `json:"expires_at"`
+	Files        int             `json:"files"`
+}
+
+type SessionDetail struct {
+	SessionInfo
+	Participants []string `json:"participants"`
+}
+
+type BroadcastRequest struct {
+	Message string `json:"message"`
+	Level   string `json:"level"`
+}
+
+// WelcomeMessage 在浏览器加入房间时发送, 保存时通过 X-Participant-ID 回传
+type WelcomeMessage struct {
+	Type          string `json:"type"`
+	ParticipantID string `json:"participantid"`
+}
+
+// CreateSessionRequest 以 JSON 创建会话, 字段与 multipart 表单一一对应, 文件内容使用 base64 编码
+type CreateSessionRequest struct {
+	Files []SessionUpload `json:"files"`
+	E2E   bool            `json:"e2e"`
+	TTL   string          `json:"ttl"`
+	Allow []string        `json:"allow"`
+	// EditorConfigFiles 是原始的 .editorconfig 文件, EditorConfig 是作用于所有文件的已解析属性
+	EditorConfigFiles []SessionUpload `json:"editorconfig_files"`
+	EditorConfig      map[string]any  `json:"editorconfig"`
+}
+
+type SessionUpload struct {
+	Path    string `json:"path"`
+	Content string `json:"content"`
+}
+
+// FileAddedMessage 在客户端程序打开新文件后发给会话中的所有浏览器
+type FileAddedMessage struct {
+	Type string      `json:"type"`
+	File SessionFile `json:"file"`
+}
+
+// ExtendRequest 延长会话, TTL 为空时延长到允许的最长生命周期
+type ExtendRequest struct {
+	TTL string `json:"ttl"`
+}
+
+// FinishRequest 结束会话, Content 为空时使用服务端保存的最新内容
+type FinishRequest struct {
+	Type    string  `json:"type"`
+	Content *string `json:"content"`
+}
@@ -1,26 +1,39 @@
 package server
 
 import (
+	"encoding/json"
 	"log/slog"
+	"remdit-server/config"
 	"sync"
+	"time"
 
 	"github.com/gofiber/contrib/websocket"
+	"github.com/google/uuid"
 )
 
+type wsMessage struct {
+	mt   int
+	data []byte
+}
+
 // 前端ws连接客户端
 type WSEditingClient struct {
+	id     string
+	room   string // 所在的文件房间
 	conn   *websocket.Conn
-	send   chan []byte
+	send   chan wsMessage
 	hub    *EditingHub
 	once   sync.Once
 	mu     sync.RWMutex
 	closed bool
 }
 
-func NewWSEditingClient(conn *websocket.Conn, hub *EditingHub) *WSEditingClient {
+func NewWSEditingClient(conn *websocket.Conn, hub *EditingHub, room string) *WSEditingClient {
 	c := &WSEditingClient{
+		id:   uuid.NewString(),
+		room: room,
 		conn: conn,
-		send: make(chan []byte, 64),
+		send: make(chan wsMessage, 64),
 		hub:  hub,
 	}
 	go c.writePump()
@@ -29,13 +42,48 @@ func NewWSEditingClient(conn *websocket.Conn, hub *EditingHub) *WSEditingClient
 
 func (c *WSEditingClient) writePump() {
 	for msg := range c.send {
-		if err := c.conn.WriteMessage(websocket.BinaryMessage, msg); err != nil {
+		if err := c.conn.WriteMessage(msg.mt, msg.data); err != nil {
 			slog.Error("client write error", "err", err)
 			break
 		}
+		if msg.mt == websocket.CloseMessage {
+			c.Close()
+			return
+		}
+	}
+}
+
+// sendMessage 非阻塞地把消息放入发送队列, 队列已满时断开该客户端
+func (c *WSEditingClient) sendMessage(msg wsMessage) {
+	// Use defer/recover to handle potential panic from sending to closed channel
+	defer func() {
+		if r := recover(); r != nil {
+			// Channel was closed, client is no longer valid
+			slog.Debug("Recovered from send to closed channel", "client", c.conn.RemoteAddr())
+		}
+	}()
+
+	// Check if client is closed before attempting to send
+	if c.IsClosed() {
+		return
+	}
+	select {
+	case c.send <- msg:
+	default:
+		slog.Warn("Client send channel full, dropping message", "client", c.conn.RemoteAddr())
+		c.Close()
 	}
 }
 
+func (c *WSEditingClient) sendJSON(v any) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		slog.Error("Failed to marshal client message", "err", err)
+		return
+	}
+	c.sendMessage(wsMessage{mt: websocket.TextMessage, data: data})
+}
+
 func (c *WSEditingClient) Close() {
 	c.once.Do(func() {
 		c.mu.Lock()
@@ -47,6 +95,12 @@ func (c *WSEditingClient) Close() {
 	})
 }
 
+// CloseWith 在已排队的消息发送完后发送带关闭码的关闭帧, 然后断开连接
+func (c *WSEditingClient) CloseWith(code int, reason string) {
+	c.sendMessage(wsMessage{mt: websocket.CloseMessage, data: websocket.FormatCloseMessage(code, reason)})
+	time.AfterFunc(config.WSWriteTimeout, c.Close)
+}
+
 func (c *WSEditingClient) IsClosed() bool {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
@@ -2,13 +2,20 @@ package server
 
 import (
 	"context"
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
 	"log/slog"
+	"remdit-server/config"
+	"remdit-server/service/quota"
 	"remdit-server/service/stors/filestor"
+	"remdit-server/service/textenc"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/gofiber/contrib/websocket"
+	"github.com/google/uuid"
 )
 
 type EditingHub struct {
@@ -16,29 +23,88 @@ type EditingHub struct {
 	clientsMu      sync.Mutex
 	clients        map[*WSEditingClient]struct{} // 前端 ws 连接
 	sessionConn    *websocket.Conn               // 客户端程序连接
+	sessionMu      sync.Mutex                    // 串行化对 sessionConn 的写入
+	saveMu         sync.Mutex                    // 同一时间只向客户端程序发起一次保存
 	saveResultChan chan SaveResult
 	chMu           sync.Mutex
+	activeMu       sync.Mutex
+	createdAt      time.Time
 	lastActiveAt   time.Time
+	closeCode      int // Cleanup 关闭连接时使用的关闭码, 0 表示直接断开
+	closeReason    string
+	finishing      atomic.Bool
+	pendingMu      sync.Mutex
+	pending        map[string]chan map[string]any // 等待客户端程序回复的请求
+	openMu         sync.Mutex                     // 避免同一路径被重复打开
+	capabilities   map[string]bool                // 客户端程序连接时声明的能力, 创建后不再修改
 }
 
-func NewEditingHub(id string, sessionConn *websocket.Conn) *EditingHub {
+// 客户端程序声明 capBase64 后才能接收 base64 编码的内容 (非 UTF-8 文本和二进制文件)
+const capBase64 = "base64"
+
+func NewEditingHub(id string, sessionConn *websocket.Conn, capabilities []string) *EditingHub {
 	now := time.Now()
+	caps := make(map[string]bool, len(capabilities))
+	for _, c := range capabilities {
+		caps[c] = true
+	}
 	return &EditingHub{
 		clients:      make(map[*WSEditingClient]struct{}),
+		pending:      make(map[string]chan map[string]any),
 		id:           id,
 		sessionConn:  sessionConn,
+		createdAt:    now,
 		lastActiveAt: now,
+		capabilities: caps,
 	}
 }
 
+// Supports 报告客户端程序是否声明了能力 capability
+func (h *EditingHub) Supports(capability string) bool {
+	return h.capabilities[capability]
+}
+
 func (h *EditingHub) updateLastActive() {
+	h.activeMu.Lock()
 	h.lastActiveAt = time.Now()
+	h.activeMu.Unlock()
+}
+
+func (h *EditingHub) LastActiveAt() time.Time {
+	h.activeMu.Lock()
+	defer h.activeMu.Unlock()
+	return h.lastActiveAt
+}
+
+// setCloseStatus 设置 Cleanup 关闭浏览器和客户端程序连接时发送的关闭码和原因
+func (h *EditingHub) setCloseStatus(code int, reason string) {
+	h.activeMu.Lock()
+	defer h.activeMu.Unlock()
+	h.closeCode, h.closeReason = code, reason
+}
+
+func (h *EditingHub) closeStatus() (int, string) {
+	h.activeMu.Lock()
+	defer h.activeMu.Unlock()
+	return h.closeCode, h.closeReason
 }
 
-func (h *EditingHub) AddClientConn(conn *websocket.Conn) *WSEditingClient {
+// writeSession 向客户端程序发送 JSON 消息
+func (h *EditingHub) writeSession(v any) error {
+	if h.sessionConn == nil {
+		return fmt.Errorf("no session connection available")
+	}
+	h.sessionMu.Lock()
+	defer h.sessionMu.Unlock()
+	h.sessionConn.SetWriteDeadline(time.Now().Add(config.WSWriteTimeout))
+	return h.sessionConn.WriteJSON(v)
+}
+
+// AddClientConn 把浏览器连接加入 room, room 是会话中某个文件的 ID
+func (h *EditingHub) AddClientConn(conn *websocket.Conn, room string) *WSEditingClient {
 	h.clientsMu.Lock()
 	defer h.clientsMu.Unlock()
-	cl := NewWSEditingClient(conn, h)
+	cl := NewWSEditingClient(conn, h, room)
 	h.clients[cl] = struct{}{}
 	return cl
 }
@@ -49,48 +115,177 @@ func (h *EditingHub) RemoveClientConn(c *WSEditingClient) {
 	h.clientsMu.Unlock()
 }
 
-func (h *EditingHub) BroadcastMessage(msg []byte) {
+// BroadcastMessage 把编辑消息转发给同一文件房间中的浏览器
+func (h *EditingHub) BroadcastMessage(room string, msg []byte) {
 	h.updateLastActive()
-	h.chMu.Lock()
+	for _, c := range h.snapshotClients() {
+		if c.room == room {
+			go c.sendMessage(wsMessage{mt: websocket.BinaryMessage, data: msg})
+		}
+	}
+}
+
+func (h *EditingHub) snapshotClients() []*WSEditingClient {
+	h.clientsMu.Lock()
+	defer h.clientsMu.Unlock()
 	clients := make([]*WSEditingClient, 0, len(h.clients))
 	for c := range h.clients {
 		clients = append(clients, c)
 	}
-	h.chMu.Unlock()
+	return clients
+}
+
+// broadcast 把消息放入每个浏览器的发送队列. sendMessage 不会阻塞, 同步入队保证消息排在
+// 之后 Cleanup 发送的关闭帧之前
+func (h *EditingHub) broadcast(msg wsMessage) {
+	clients := h.snapshotClients()
 	for _, c := range clients {
-		go func(client *WSEditingClient) {
-			// Use defer/recover to handle potential panic from sending to closed channel
-			defer func() {
-				if r := recover(); r != nil {
-					// Channel was closed, client is no longer valid
-					slog.Debug("Recovered from send to closed channel", "client", client.conn.RemoteAddr())
-				}
-			}()
-			
-			// Check if client is closed before attempting to send
-			if client.IsClosed() {
-				return
-			}
-			select {
-			case client.send <- msg:
-			default:
-				slog.Warn("Client send channel full, dropping message", "client", client.conn.RemoteAddr())
-				client.Close()
-			}
-		}(c)
-	}
-}
-
-func (h *EditingHub) NotifySessionSave(content string) error {
-	h.updateLastActive()
-	if h.sessionConn == nil {
-		return fmt.Errorf("no session connection available")
+		c.sendMessage(msg)
 	}
+}
+
+// NotifySessionSave 把文件 f 的内容发送给客户端程序, e2e 会话的内容是原样转发的密文.
+// 二进制和不是 UTF-8 的内容使用 base64 编码, 客户端程序解码后按原样写入.
+// finish 为 true 时客户端程序应在保存后退出
+func (h *EditingHub) NotifySessionSave(f filestor.File, data []byte, finish bool) error {
+	h.updateLastActive()
 	saveMsg := map[string]any{
 		"type":    "save",
-		"content": content,
+		"fileid":  f.ID(),
+		"path":    f.Path(),
+		"content": string(data),
+	}
+	// 不认识 finish 字段的旧客户端程序照常保存, 会话随后由服务端关闭
+	if finish {
+		saveMsg["finish"] = true
+	}
+	if needsBase64(f.E2E(), f.Binary(), f.Encoding()) {
+		if !h.Supports(capBase64) {
+			return fmt.Errorf("client does not support base64 content")
+		}
+		saveMsg["content"] = base64.StdEncoding.EncodeToString(data)
+		saveMsg["base64"] = true
+	}
+	switch {
+	case f.E2E():
+		saveMsg["e2e"] = true
+	case f.Binary():
+		saveMsg["binary"] = true
+	default:
+		saveMsg["encoding"] = f.Encoding()
+	}
+	return h.writeSession(saveMsg)
+}
+
+// needsBase64 报告发给客户端程序的内容是否需要 base64 编码, e2e 的密文总是原样发送
+func needsBase64(e2e, binary bool, enc textenc.Encoding) bool {
+	return !e2e && (binary || !enc.IsUTF8())
+}
+
+// BroadcastNotice 向所有浏览器和客户端程序发送一条文本通知
+func (h *EditingHub) BroadcastNotice(level, message string) {
+	notice := NoticeMessage{Type: "notice", Level: level, Message: message}
+	data, err := json.Marshal(notice)
+	if err != nil {
+		return
+	}
+	h.broadcast(wsMessage{mt: websocket.TextMessage, data: data})
+	if err := h.writeSession(notice); err != nil {
+		slog.Warn("Failed to send notice to session", "sessionid", h.id, "err", err)
+	}
+}
+
+// BroadcastFileAdded 通知所有浏览器会话中新增了文件
+func (h *EditingHub) BroadcastFileAdded(f SessionFile) {
+	data, err := json.Marshal(FileAddedMessage{Type: "file_added", File: f})
+	if err != nil {
+		return
+	}
+	h.broadcast(wsMessage{mt: websocket.TextMessage, data: data})
+}
+
+func (h *EditingHub) ClientCount() int {
+	h.clientsMu.Lock()
+	defer h.clientsMu.Unlock()
+	return len(h.clients)
+}
+
+// HasParticipant 报告 id 是否为文件房间 room 中已连接的浏览器
+func (h *EditingHub) HasParticipant(room, id string) bool {
+	h.clientsMu.Lock()
+	defer h.clientsMu.Unlock()
+	for c := range h.clients {
+		if c.room == room && c.id == id {
+			return true
+		}
+	}
+	return false
+}
+
+// RoomClientCount 返回正在编辑某个文件的浏览器数量
+func (h *EditingHub) RoomClientCount(room string) int {
+	h.clientsMu.Lock()
+	defer h.clientsMu.Unlock()
+	n := 0
+	for c := range h.clients {
+		if c.room == room {
+			n++
+		}
+	}
+	return n
+}
+
+// ClientAddrs 返回所有浏览器连接的远端地址
+func (h *EditingHub) ClientAddrs() []string {
+	clients := h.snapshotClients()
+	addrs := make([]string, 0, len(clients))
+	for _, c := range clients {
+		addrs = append(addrs, c.conn.RemoteAddr().String())
+	}
+	return addrs
+}
+
+// requestSession 向客户端程序发送带 requestid 的请求, 并等待带同一 requestid 的回复
+func (h *EditingHub) requestSession(msg map[string]any) (map[string]any, error) {
+	id := uuid.NewString()
+	ch := make(chan map[string]any, 1)
+	h.pendingMu.Lock()
+	h.pending[id] = ch
+	h.pendingMu.Unlock()
+	defer func() {
+		h.pendingMu.Lock()
+		delete(h.pending, id)
+		h.pendingMu.Unlock()
+	}()
+
+	msg["requestid"] = id
+	if err := h.writeSession(msg); err != nil {
+		return nil, err
+	}
+	select {
+	case resp := <-ch:
+		if reason, _ := resp["error"].(string); reason != "" {
+			return nil, &clientError{reason: reason}
+		}
+		return resp, nil
+	case <-time.After(10 * time.Second):
+		return nil, errRequestTimeout
+	}
+}
+
+func (h *EditingHub) resolveRequest(msg map[string]any) {
+	id, _ := msg["requestid"].(string)
+	h.pendingMu.Lock()
+	ch, ok := h.pending[id]
+	h.pendingMu.Unlock()
+	if !ok {
+		slog.Debug("Dropping reply for unknown request", "sessionid", h.id, "requestid", id)
+		return
+	}
+	select {
+	case ch <- msg:
+	default:
 	}
-	return h.sessionConn.WriteJSON(saveMsg)
 }
 
 func (h *EditingHub) HandleSaveResult(success bool, reason string) {
@@ -132,14 +327,25 @@ func (h *EditingHub) Cleanup() {
 	h.clients = make(map[*WSEditingClient]struct{})
 	h.clientsMu.Unlock()
 
+	code, reason := h.closeStatus()
 	for _, client := range clients {
-		client.Close()
+		if code != 0 {
+			client.CloseWith(code, reason)
+		} else {
+			client.Close()
+		}
 	}
 
 	if h.sessionConn != nil {
+		if code != 0 {
+			h.sessionMu.Lock()
+			h.sessionConn.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(config.WSWriteTimeout))
+			h.sessionMu.Unlock()
+		}
 		h.sessionConn.Close()
 	}
-	if err := filestor.Delete(context.Background(), h.id); err != nil {
+	quota.Release(h.id)
+	if err := filestor.DeleteSession(context.Background(), h.id); err != nil {
 		slog.Error("Failed to delete file", "fileid", h.id, "err", err)
 	} else {
 		slog.Info("Cleaned up session files", "fileid", h.id)
@@ -1,9 +1,14 @@
 package server
 
 import (
+	"context"
 	"fmt"
 	"log/slog"
 	"remdit-server/config"
+	"remdit-server/service/audit"
+	"remdit-server/service/events"
+	"remdit-server/service/quota"
+	"remdit-server/service/stors/filestor"
 	"sync"
 	"time"
 
@@ -11,16 +16,13 @@ import (
 )
 
 type HubManager struct {
-	mu   sync.Mutex
-	hubs map[string]*EditingHub
-}
-
-func init() {
-	go hubManager.startIntervalCleanup()
+	mu     sync.Mutex
+	hubs   map[string]*EditingHub
+	warned map[string]time.Time // 已发出到期提醒的会话及提醒时对应的到期时间
 }
 
 func NewHubManager() *HubManager {
-	return &HubManager{hubs: make(map[string]*EditingHub)}
+	return &HubManager{hubs: make(map[string]*EditingHub), warned: make(map[string]time.Time)}
 }
 
 func (m *HubManager) GetHub(room string) *EditingHub {
@@ -32,13 +34,13 @@ func (m *HubManager) GetHub(room string) *EditingHub {
 	return nil
 }
 
-func (m *HubManager) CreateHub(room string, sessionConn *websocket.Conn) (*EditingHub, error) {
+func (m *HubManager) CreateHub(room string, sessionConn *websocket.Conn, capabilities []string) (*EditingHub, error) {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 	if _, exists := m.hubs[room]; exists {
 		return nil, fmt.Errorf("hub already exists for room: %s", room)
 	}
-	hub := NewEditingHub(room, sessionConn)
+	hub := NewEditingHub(room, sessionConn, capabilities)
 	m.hubs[room] = hub
 	slog.Debug("Created new editing hub", "room", room)
 	return hub, nil
@@ -56,9 +58,32 @@ func (m *HubManager) CleanupSession(sessionID string) {
 	m.mu.Unlock()
 
 	hub.Cleanup()
+	_, reason := hub.closeStatus()
+	events.Publish(events.SessionCleanedUp, sessionID, nil)
+	audit.Record(audit.Entry{Action: audit.SessionTornDown, SessionID: sessionID, Reason: reason})
 	slog.Info("cleaned up session", "sessionid", sessionID)
 }
 
+// 会话被管理员, 磁盘预算或到期关闭时, 浏览器和客户端程序收到的关闭码, 关闭原因为 CloseSession 的 reason
+const closeSessionClosed = 4002
+
+// CloseSession 通知参与者后结束会话, 客户端程序尚未连接时直接删除文件.
+// 通知排在关闭帧之前, 所以参与者总能在断开前收到
+func (m *HubManager) CloseSession(sessionID, notice, reason string) {
+	if hub := m.GetHub(sessionID); hub != nil {
+		hub.setCloseStatus(closeSessionClosed, reason)
+		hub.BroadcastNotice("warning", notice)
+		m.CleanupSession(sessionID)
+		return
+	}
+	quota.Release(sessionID)
+	if err := filestor.DeleteSession(context.Background(), sessionID); err != nil {
+		slog.Error("Failed to delete file", "fileid", sessionID, "err", err)
+	}
+	events.Publish(events.SessionCleanedUp, sessionID, nil)
+	audit.Record(audit.Entry{Action: audit.SessionTornDown, SessionID: sessionID, Reason: reason})
+}
+
 func (m *HubManager) ExistsHub(room string) bool {
 	m.mu.Lock()
 	defer m.mu.Unlock()
@@ -66,23 +91,39 @@ func (m *HubManager) ExistsHub(room string) bool {
 	return exists
 }
 
-func (m *HubManager) startIntervalCleanup() {
-	ticker := time.NewTicker(30 * time.Minute)
-	defer ticker.Stop()
+// Hubs 返回当前所有 hub 的快照
+func (m *HubManager) Hubs() map[string]*EditingHub {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	hubs := make(map[string]*EditingHub, len(m.hubs))
+	for id, hub := range m.hubs {
+		hubs[id] = hub
+	}
+	return hubs
+}
 
-	for range ticker.C {
+// startIntervalCleanup 按 session_check_interval_seconds 周期清理空闲和到期的会话, 直到 ctx 结束
+func (m *HubManager) startIntervalCleanup(ctx context.Context) {
+	for {
+		timer := time.NewTimer(time.Duration(config.C().SessionCheckSec) * time.Second)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return
+		case <-timer.C:
+		}
 		m.cleanupExpiredSessions()
 	}
 }
 
 func (m *HubManager) cleanupExpiredSessions() {
-	sessionTimeout := time.Duration(config.C.SessionTimeoutHours) * time.Hour
+	sessionTimeout := time.Duration(config.C().SessionTimeoutHours) * time.Hour
 	m.mu.Lock()
 	expiredSessions := make([]string, 0)
 	now := time.Now()
 
 	for sessionID, hub := range m.hubs {
-		if hub.IsEmpty() && now.Sub(hub.lastActiveAt) > sessionTimeout {
+		if hub.IsEmpty() && now.Sub(hub.LastActiveAt()) > sessionTimeout {
 			expiredSessions = append(expiredSessions, sessionID)
 		}
 	}
@@ -92,4 +133,47 @@ func (m *HubManager) cleanupExpiredSessions() {
 		slog.Info("Cleaning up expired session", "sessionid", sessionID)
 		m.CleanupSession(sessionID)
 	}
+	m.enforceLifetimes(now)
+}
+
+// enforceLifetimes 结束超过到期时间的会话, 并在到期前向房间和客户端程序发出提醒
+func (m *HubManager) enforceLifetimes(now time.Time) {
+	warning := time.Duration(config.C().SessionWarningMin) * time.Minute
+	live := make(map[string]bool)
+	for _, f := range filestor.List(context.Background()) {
+		if f.ID() != f.SessionID() {
+			// 到期时间记录在会话的第一个文件上
+			continue
+		}
+		id, expiresAt := f.ID(), f.ExpiresAt()
+		live[id] = true
+		if expiresAt.IsZero() {
+			continue
+		}
+		if !now.Before(expiresAt) {
+			slog.Info("Session reached its lifetime, tearing down", "sessionid", id, "expires_at", expiresAt)
+			m.CloseSession(id, "This session has expired", "lifetime expired")
+			continue
+		}
+		if expiresAt.Sub(now) > warning {
+			continue
+		}
+		m.mu.Lock()
+		hub, warnedFor := m.hubs[id], m.warned[id]
+		if hub != nil && !warnedFor.Equal(expiresAt) {
+			m.warned[id] = expiresAt
+		}
+		m.mu.Unlock()
+		if hub != nil && !warnedFor.Equal(expiresAt) {
+			left := expiresAt.Sub(now).Round(time.Second)
+			hub.BroadcastNotice("warning", fmt.Sprintf("This session expires in %s at %s", left, expiresAt.UTC().Format(time.RFC3339)))
+		}
+	}
+	m.mu.Lock()
+	for id := range m.warned {
+		if !live[id] {
+			delete(m.warned, id)
+		}
+	}
+	m.mu.Unlock()
 }
@@ -0,0 +1,125 @@
+package apikey
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+type Scope string
+
+const (
+	ScopeCreateSession Scope = "session:create"
+	ScopeAdmin         Scope = "admin"
+	ScopeReadMetrics   Scope = "metrics:read"
+)
+
+var AllScopes = []Scope{ScopeCreateSession, ScopeAdmin, ScopeReadMetrics}
+
+const tokenPrefix = "rmd_"
+
+// Quota 限制单个 key 的资源用量, 零值表示不限制
+type Quota struct {
+	MaxConcurrentSessions int   `json:"max_concurrent_sessions,omitempty"`
+	SessionsPerDay        int   `json:"sessions_per_day,omitempty"`
+	MaxStorageBytes       int64 `json:"max_storage_bytes,omitempty"`
+	MaxFileSize           int64 `json:"max_file_size,omitempty"`
+}
+
+// Key 是持久化在 key 文件中的 API key, 只保存加盐哈希
+type Key struct {
+	ID        string    `json:"id"`
+	Name      string    `json:"name"`
+	Salt      string    `json:"salt"`
+	Hash      string    `json:"hash"`
+	Scopes    []Scope   `json:"scopes"`
+	Quota     Quota     `json:"quota"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at,omitzero"`
+	RevokedAt time.Time `json:"revoked_at,omitzero"`
+}
+
+func (k *Key) HasScope(scope Scope) bool {
+	return slices.Contains(k.Scopes, scope) || slices.Contains(k.Scopes, ScopeAdmin)
+}
+
+func (k *Key) Expired(now time.Time) bool {
+	return !k.ExpiresAt.IsZero() && now.After(k.ExpiresAt)
+}
+
+func (k *Key) Revoked() bool {
+	return !k.RevokedAt.IsZero()
+}
+
+func (k *Key) matches(secret string) bool {
+	salt, err := hex.DecodeString(k.Salt)
+	if err != nil {
+		return false
+	}
+	want, err := hex.DecodeString(k.Hash)
+	if err != nil {
+		return false
+	}
+	got := hashSecret(salt, secret)
+	return subtle.ConstantTimeCompare(got, want) == 1
+}
+
+func hashSecret(salt []byte, secret string) []byte {
+	h := sha256.New()
+	h.Write(salt)
+	h.Write([]byte(secret))
+	return h.Sum(nil)
+}
+
+func randomBytes(n int) []byte {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Sprintf("crypto/rand failed: %v", err))
+	}
+	return b
+}
+
+// Generate 生成新的 key, 返回的 token 只在此时可见
+func Generate(name string, scopes []Scope, quota Quota, ttl time.Duration) (*Key, string) {
+	id := hex.EncodeToString(randomBytes(4))
+	secret := base64.RawURLEncoding.EncodeToString(randomBytes(32))
+	salt := randomBytes(16)
+	now := time.Now()
+	key := &Key{
+		ID:        id,
+		Name:      name,
+		Salt:      hex.EncodeToString(salt),
+		Hash:      hex.EncodeToString(hashSecret(salt, secret)),
+		Scopes:    scopes,
+		Quota:     quota,
+		CreatedAt: now,
+	}
+	if ttl > 0 {
+		key.ExpiresAt = now.Add(ttl)
+	}
+	return key, tokenPrefix + id + "_" + secret
+}
+
+// parseToken 拆分 rmd_<id>_<secret> 格式的 token
+func parseToken(token string) (id, secret string, ok bool) {
+	rest, found := strings.CutPrefix(token, tokenPrefix)
+	if !found {
+		return "", "", false
+	}
+	id, secret, ok = strings.Cut(rest, "_")
+	return id, secret, ok && id != "" && secret != ""
+}
+
+func ParseScope(s string) (Scope, error) {
+	scope := Scope(s)
+	if !slices.Contains(AllScopes, scope) {
+		return "", fmt.Errorf("unknown scope %q", s)
+	}
+	return scope, nil
+}
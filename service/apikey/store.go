@@ -0,0 +1,150 @@
+package apikey
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"remdit-server/config"
+	"sync"
+	"time"
+)
+
+var (
+	ErrInvalidKey = errors.New("invalid api key")
+	ErrExpiredKey = errors.New("api key expired")
+	ErrRevokedKey = errors.New("api key revoked")
+)
+
+type keyFile struct {
+	Keys []*Key `json:"keys"`
+}
+
+// Store 是 key 文件的内存视图, 文件修改后自动重新加载
+type Store struct {
+	mu      sync.RWMutex
+	path    string
+	modTime time.Time
+	keys    map[string]*Key
+}
+
+var defaultStore = &Store{}
+
+func Default() *Store {
+	return defaultStore
+}
+
+// Load 从 path 读取 key 文件, 文件不存在时返回空列表
+func Load(path string) ([]*Key, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to read key file %s: %w", path, err)
+	}
+	var kf keyFile
+	if err := json.Unmarshal(data, &kf); err != nil {
+		return nil, fmt.Errorf("failed to parse key file %s: %w", path, err)
+	}
+	return kf.Keys, nil
+}
+
+// Write 原子地将 keys 写入 path
+func Write(path string, keys []*Key) error {
+	data, err := json.MarshalIndent(keyFile{Keys: keys}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if dir := filepath.Dir(path); dir != "" {
+		if err := os.MkdirAll(dir, 0700); err != nil {
+			return err
+		}
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".apikeys-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Chmod(0600); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+func (s *Store) refresh(path string) error {
+	var modTime time.Time
+	if info, err := os.Stat(path); err == nil {
+		modTime = info.ModTime()
+	}
+	s.mu.RLock()
+	fresh := s.keys != nil && s.path == path && s.modTime.Equal(modTime)
+	s.mu.RUnlock()
+	if fresh {
+		return nil
+	}
+
+	keys, err := Load(path)
+	if err != nil {
+		return err
+	}
+	byID := make(map[string]*Key, len(keys))
+	for _, k := range keys {
+		byID[k.ID] = k
+	}
+	s.mu.Lock()
+	s.path, s.modTime, s.keys = path, modTime, byID
+	s.mu.Unlock()
+	return nil
+}
+
+// Authenticate 校验 token 并返回对应的 key
+func (s *Store) Authenticate(token string) (*Key, error) {
+	cfg := config.C()
+	if id, secret, ok := parseToken(token); ok && cfg.APIKeysFile != "" {
+		if err := s.refresh(cfg.APIKeysFile); err != nil {
+			return nil, err
+		}
+		s.mu.RLock()
+		key, exists := s.keys[id]
+		s.mu.RUnlock()
+		if exists && key.matches(secret) {
+			switch {
+			case key.Revoked():
+				return nil, ErrRevokedKey
+			case key.Expired(time.Now()):
+				return nil, ErrExpiredKey
+			}
+			return key, nil
+		}
+	}
+
+	// 兼容 config 中明文配置的 key, 拥有全部权限
+	hashed := sha256.Sum256([]byte(token))
+	for i, k := range cfg.APIKeys {
+		hashedKey := sha256.Sum256([]byte(k))
+		if subtle.ConstantTimeCompare(hashed[:], hashedKey[:]) == 1 {
+			return &Key{
+				ID:     fmt.Sprintf("config-%d", i),
+				Name:   fmt.Sprintf("config-%d", i),
+				Scopes: []Scope{ScopeAdmin},
+			}, nil
+		}
+	}
+	return nil, ErrInvalidKey
+}
+
+func Authenticate(token string) (*Key, error) {
+	return defaultStore.Authenticate(token)
+}
@@ -0,0 +1,239 @@
+package audit
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"remdit-server/config"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Action string
+
+const (
+	SessionCreated  Action = "session.created"
+	FileAdded       Action = "file.added"
+	RoomJoined      Action = "room.joined"
+	SaveAttempted   Action = "save.attempted"
+	SessionTornDown Action = "session.torn_down"
+)
+
+// Entry 是审计日志中的一行
+type Entry struct {
+	Time          time.Time `json:"time"`
+	Action        Action    `json:"action"`
+	SessionID     string    `json:"sessionid"`
+	IP            string    `json:"ip,omitempty"`
+	KeyName       string    `json:"key_name,omitempty"`
+	ParticipantID string    `json:"participantid,omitempty"`
+	Filename      string    `json:"filename,omitempty"`
+	Size          int64     `json:"size,omitempty"`
+	SHA256        string    `json:"sha256,omitempty"`
+	Result        string    `json:"result,omitempty"`
+	Reason        string    `json:"reason,omitempty"`
+}
+
+// Logger 以 JSONL 追加写入审计日志, 超过大小限制时轮转
+type Logger struct {
+	mu   sync.Mutex
+	path string
+	file *os.File
+	size int64
+}
+
+var defaultLogger = &Logger{}
+
+func Default() *Logger {
+	return defaultLogger
+}
+
+func (l *Logger) open(path string) error {
+	if l.file != nil && l.path == path {
+		return nil
+	}
+	if l.file != nil {
+		l.file.Close()
+		l.file = nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		return err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	l.path, l.file, l.size = path, f, info.Size()
+	return nil
+}
+
+func (l *Logger) rotate(cfg *config.Config) error {
+	l.file.Close()
+	l.file = nil
+	ext := filepath.Ext(l.path)
+	rotated := fmt.Sprintf("%s-%s%s", strings.TrimSuffix(l.path, ext), time.Now().UTC().Format("20060102T150405.000000000"), ext)
+	if err := os.Rename(l.path, rotated); err != nil {
+		return err
+	}
+	if cfg.AuditMaxBackups > 0 {
+		backups, err := backupFiles(l.path)
+		if err != nil {
+			return err
+		}
+		for len(backups) > cfg.AuditMaxBackups {
+			if err := os.Remove(backups[0]); err != nil {
+				return err
+			}
+			backups = backups[1:]
+		}
+	}
+	return l.open(l.path)
+}
+
+// backupFiles 返回 path 的轮转文件, 按时间从旧到新排序
+func backupFiles(path string) ([]string, error) {
+	ext := filepath.Ext(path)
+	matches, err := filepath.Glob(strings.TrimSuffix(path, ext) + "-*" + ext)
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(matches)
+	return matches, nil
+}
+
+// Record 写入一条审计记录, 未配置 audit_log 时什么都不做
+func (l *Logger) Record(e Entry) {
+	cfg := config.C()
+	if cfg.AuditLog == "" {
+		return
+	}
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	line, err := json.Marshal(e)
+	if err != nil {
+		slog.Error("Failed to marshal audit entry", "err", err)
+		return
+	}
+	line = append(line, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if err := l.open(cfg.AuditLog); err != nil {
+		slog.Error("Failed to open audit log", "path", cfg.AuditLog, "err", err)
+		return
+	}
+	maxSize := int64(cfg.AuditMaxSizeMB) * 1024 * 1024
+	if maxSize > 0 && l.size > 0 && l.size+int64(len(line)) > maxSize {
+		if err := l.rotate(cfg); err != nil {
+			slog.Error("Failed to rotate audit log", "path", cfg.AuditLog, "err", err)
+			if l.file == nil {
+				return
+			}
+		}
+	}
+	n, err := l.file.Write(line)
+	l.size += int64(n)
+	if err != nil {
+		slog.Error("Failed to write audit log", "path", cfg.AuditLog, "err", err)
+	}
+}
+
+// Query 返回会话的全部审计记录, 包括已轮转的文件.
+// 只在持有锁时打开文件, 扫描时不阻塞 Record; 打开的文件在轮转和删除后仍然可读
+func (l *Logger) Query(sessionID string) ([]Entry, error) {
+	cfg := config.C()
+	if cfg.AuditLog == "" {
+		return nil, fmt.Errorf("audit log is disabled")
+	}
+	readers, err := l.snapshot(cfg.AuditLog)
+	if err != nil {
+		return nil, err
+	}
+	defer closeAll(readers)
+
+	entries := make([]Entry, 0)
+	needle := `"sessionid":"` + sessionID + `"`
+	for _, r := range readers {
+		if err := scanLines(r, func(line []byte) {
+			if !strings.Contains(string(line), needle) {
+				return
+			}
+			var e Entry
+			if err := json.Unmarshal(line, &e); err == nil && e.SessionID == sessionID {
+				entries = append(entries, e)
+			}
+		}); err != nil {
+			return nil, err
+		}
+	}
+	return entries, nil
+}
+
+// snapshot 在锁内按从旧到新的顺序打开审计日志的全部文件.
+// 每个文件只读取到打开时的大小, 不会读到之后正在写入的半行
+func (l *Logger) snapshot(path string) ([]io.ReadCloser, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	files, err := backupFiles(path)
+	if err != nil {
+		return nil, err
+	}
+	files = append(files, path)
+	readers := make([]io.ReadCloser, 0, len(files))
+	for _, name := range files {
+		f, err := os.Open(name)
+		if os.IsNotExist(err) {
+			continue
+		}
+		if err != nil {
+			closeAll(readers)
+			return nil, err
+		}
+		info, err := f.Stat()
+		if err != nil {
+			f.Close()
+			closeAll(readers)
+			return nil, err
+		}
+		readers = append(readers, struct {
+			io.Reader
+			io.Closer
+		}{io.LimitReader(f, info.Size()), f})
+	}
+	return readers, nil
+}
+
+func closeAll(readers []io.ReadCloser) {
+	for _, r := range readers {
+		r.Close()
+	}
+}
+
+func scanLines(r io.Reader, fn func(line []byte)) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		fn(scanner.Bytes())
+	}
+	return scanner.Err()
+}
+
+func Record(e Entry) {
+	defaultLogger.Record(e)
+}
+
+func Query(sessionID string) ([]Entry, error) {
+	return defaultLogger.Query(sessionID)
+}
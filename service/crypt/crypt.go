@@ -0,0 +1,151 @@
+package crypt
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"remdit-server/config"
+	"sync"
+)
+
+const dataKeySize = 32
+
+// magic 标记加密后的内容, 后跟 nonce 和 AES-GCM 密文
+var magic = []byte("RMDE1")
+
+var ErrShredded = errors.New("data key has been shredded")
+
+// KeyWrapper 使用主密钥包装每个会话的数据密钥
+type KeyWrapper struct {
+	aead cipher.AEAD
+}
+
+var defaultWrapper *KeyWrapper
+
+// Default 返回主密钥对应的 KeyWrapper, 未配置 encryption_key 时为 nil
+func Default() *KeyWrapper {
+	return defaultWrapper
+}
+
+// Init 根据配置创建默认 KeyWrapper, 只应在启动时调用一次
+func Init(cfg *config.Config) error {
+	if cfg.EncryptionKey == "" {
+		defaultWrapper = nil
+		return nil
+	}
+	master, err := base64.StdEncoding.DecodeString(cfg.EncryptionKey)
+	if err != nil {
+		return fmt.Errorf("encryption_key is not valid base64: %w", err)
+	}
+	w, err := NewKeyWrapper(master)
+	if err != nil {
+		return err
+	}
+	defaultWrapper = w
+	return nil
+}
+
+func NewKeyWrapper(master []byte) (*KeyWrapper, error) {
+	if len(master) != 32 {
+		return nil, fmt.Errorf("master key must be 32 bytes, got %d", len(master))
+	}
+	aead, err := newGCM(master)
+	if err != nil {
+		return nil, err
+	}
+	return &KeyWrapper{aead: aead}, nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func seal(aead cipher.AEAD, plaintext, aad []byte) []byte {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		panic(fmt.Sprintf("crypto/rand failed: %v", err))
+	}
+	return aead.Seal(nonce, nonce, plaintext, aad)
+}
+
+func open(aead cipher.AEAD, sealed, aad []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, fmt.Errorf("ciphertext too short")
+	}
+	nonce, ciphertext := sealed[:aead.NonceSize()], sealed[aead.NonceSize():]
+	return aead.Open(nil, nonce, ciphertext, aad)
+}
+
+// DataKey 是被主密钥包装的会话数据密钥, 明文密钥只在加解密时短暂存在
+type DataKey struct {
+	mu      sync.Mutex
+	wrapper *KeyWrapper
+	wrapped []byte
+}
+
+// NewDataKey 生成一个新的数据密钥
+func (w *KeyWrapper) NewDataKey() *DataKey {
+	key := make([]byte, dataKeySize)
+	if _, err := rand.Read(key); err != nil {
+		panic(fmt.Sprintf("crypto/rand failed: %v", err))
+	}
+	defer clear(key)
+	return &DataKey{wrapper: w, wrapped: seal(w.aead, key, nil)}
+}
+
+func (k *DataKey) withKey(fn func(aead cipher.AEAD) error) error {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.wrapped == nil {
+		return ErrShredded
+	}
+	key, err := open(k.wrapper.aead, k.wrapped, nil)
+	if err != nil {
+		return fmt.Errorf("failed to unwrap data key: %w", err)
+	}
+	defer clear(key)
+	aead, err := newGCM(key)
+	if err != nil {
+		return err
+	}
+	return fn(aead)
+}
+
+// Encrypt 加密 plaintext, aad 绑定内容所在的位置
+func (k *DataKey) Encrypt(plaintext, aad []byte) ([]byte, error) {
+	var out []byte
+	err := k.withKey(func(aead cipher.AEAD) error {
+		out = append(bytes.Clone(magic), seal(aead, plaintext, aad)...)
+		return nil
+	})
+	return out, err
+}
+
+func (k *DataKey) Decrypt(data, aad []byte) ([]byte, error) {
+	if !bytes.HasPrefix(data, magic) {
+		return nil, fmt.Errorf("content is not encrypted")
+	}
+	var out []byte
+	err := k.withKey(func(aead cipher.AEAD) error {
+		var err error
+		out, err = open(aead, data[len(magic):], aad)
+		return err
+	})
+	return out, err
+}
+
+// Shred 销毁数据密钥, 之后用它加密的内容将无法解密
+func (k *DataKey) Shred() {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	clear(k.wrapped)
+	k.wrapped = nil
+}
@@ -0,0 +1,57 @@
+package editorconfig
+
+import (
+	"remdit-server/service/textenc"
+	"remdit-server/service/textfmt"
+	"strconv"
+)
+
+// Encoding 用 charset 属性覆盖 enc, 不认识的 charset 保持不变
+func (p Properties) Encoding(enc textenc.Encoding) textenc.Encoding {
+	switch p["charset"] {
+	case "utf-8":
+		return textenc.Encoding{Name: textenc.UTF8}
+	case "utf-8-bom":
+		return textenc.Encoding{Name: textenc.UTF8, BOM: true}
+	case "utf-16le":
+		return textenc.Encoding{Name: textenc.UTF16LE, BOM: true}
+	case "utf-16be":
+		return textenc.Encoding{Name: textenc.UTF16BE, BOM: true}
+	case "latin1":
+		return textenc.Encoding{Name: textenc.Windows1252}
+	}
+	return enc
+}
+
+// Format 用 end_of_line, insert_final_newline, indent_style, indent_size
+// 和 trim_trailing_whitespace 属性覆盖 tf
+func (p Properties) Format(tf textfmt.Format) textfmt.Format {
+	if le, err := textfmt.ParseLineEnding(p["end_of_line"]); err == nil {
+		tf.LineEnding = le
+	}
+	switch p["insert_final_newline"] {
+	case "true":
+		tf.FinalNewline = true
+	case "false":
+		tf.FinalNewline = false
+	}
+	switch p["indent_style"] {
+	case "tab":
+		tf.Indent, tf.ConvertIndent = textfmt.IndentTab, true
+		if n, err := strconv.Atoi(p["tab_width"]); err == nil && n > 0 {
+			tf.IndentSize = n
+		}
+	case "space":
+		tf.Indent, tf.ConvertIndent = textfmt.IndentSpace, true
+		if n, err := strconv.Atoi(p["indent_size"]); err == nil && n > 0 {
+			tf.IndentSize = n
+		}
+	}
+	switch p["trim_trailing_whitespace"] {
+	case "true":
+		tf.TrimTrailingWhitespace = true
+	case "false":
+		tf.TrimTrailingWhitespace = false
+	}
+	return tf
+}
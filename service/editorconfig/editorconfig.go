@@ -0,0 +1,270 @@
+package editorconfig
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Properties 是解析后的属性, 键和已知属性的值都是小写
+type Properties map[string]string
+
+// 值不区分大小写的已知属性
+var knownProps = map[string]bool{
+	"indent_style": true, "indent_size": true, "tab_width": true, "end_of_line": true,
+	"charset": true, "insert_final_newline": true, "trim_trailing_whitespace": true, "root": true,
+}
+
+type section struct {
+	glob  string
+	props Properties
+}
+
+// File 是一个 .editorconfig 文件的内容
+type File struct {
+	Root     bool
+	sections []section
+}
+
+// Source 是位于 Dir 目录下的 .editorconfig, Dir 是相对于会话根目录的 '/' 分隔路径
+type Source struct {
+	Dir  string
+	File *File
+}
+
+// Parse 解析 .editorconfig 文件, 格式遵循 https://spec.editorconfig.org
+func Parse(data []byte) (*File, error) {
+	f := &File{}
+	var cur *section
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for n := 1; scanner.Scan(); n++ {
+		line := strings.TrimSpace(scanner.Text())
+		if n == 1 {
+			line = strings.TrimPrefix(line, "\ufeff")
+		}
+		if line == "" || line[0] == '#' || line[0] == ';' {
+			continue
+		}
+		if line[0] == '[' {
+			if !strings.HasSuffix(line, "]") || len(line) < 3 {
+				return nil, fmt.Errorf("line %d: invalid section header", n)
+			}
+			f.sections = append(f.sections, section{glob: line[1 : len(line)-1], props: Properties{}})
+			cur = &f.sections[len(f.sections)-1]
+			continue
+		}
+		key, value, ok := strings.Cut(line, "=")
+		if !ok {
+			return nil, fmt.Errorf("line %d: expected key = value", n)
+		}
+		key = strings.ToLower(strings.TrimSpace(key))
+		value = strings.TrimSpace(value)
+		if knownProps[key] {
+			value = strings.ToLower(value)
+		}
+		if cur == nil {
+			// 第一个 section 之前只有 root 有意义
+			if key == "root" {
+				f.Root = value == "true"
+			}
+			continue
+		}
+		cur.props[key] = value
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+// Resolve 计算会话中路径 p 的属性: 离文件越近的 .editorconfig 优先,
+// 遇到 root = true 的文件后不再查找上级目录
+func Resolve(sources []Source, p string) Properties {
+	var applicable []Source
+	for _, s := range sources {
+		if s.File != nil && (s.Dir == "." || s.Dir == "" || strings.HasPrefix(p, s.Dir+"/")) {
+			applicable = append(applicable, s)
+		}
+	}
+	sort.SliceStable(applicable, func(i, j int) bool { return depth(applicable[i].Dir) > depth(applicable[j].Dir) })
+	for i, s := range applicable {
+		if s.File.Root {
+			applicable = applicable[:i+1]
+			break
+		}
+	}
+	props := Properties{}
+	for i := len(applicable) - 1; i >= 0; i-- {
+		s := applicable[i]
+		rel := p
+		if s.Dir != "." && s.Dir != "" {
+			rel = strings.TrimPrefix(p, s.Dir+"/")
+		}
+		for _, sec := range s.File.sections {
+			if !match(sec.glob, rel) {
+				continue
+			}
+			for k, v := range sec.props {
+				props[k] = v
+			}
+		}
+	}
+	for k, v := range props {
+		if v == "unset" {
+			delete(props, k)
+		}
+	}
+	// indent_size = tab 时使用 tab_width, 反之亦然
+	if props["indent_style"] == "tab" && props["indent_size"] == "" {
+		props["indent_size"] = "tab"
+	}
+	if props["indent_size"] == "tab" && props["tab_width"] != "" {
+		props["indent_size"] = props["tab_width"]
+	}
+	if props["tab_width"] == "" {
+		if _, err := strconv.Atoi(props["indent_size"]); err == nil {
+			props["tab_width"] = props["indent_size"]
+		}
+	}
+	return props
+}
+
+// Normalize 把调用方提供的已解析属性转换为和 Parse 一致的形式
+func Normalize(raw map[string]string) Properties {
+	props := make(Properties, len(raw))
+	for k, v := range raw {
+		k = strings.ToLower(strings.TrimSpace(k))
+		v = strings.TrimSpace(v)
+		if knownProps[k] {
+			v = strings.ToLower(v)
+		}
+		if k != "" && v != "" && v != "unset" {
+			props[k] = v
+		}
+	}
+	return props
+}
+
+func depth(dir string) int {
+	if dir == "." || dir == "" {
+		return 0
+	}
+	return strings.Count(dir, "/") + 1
+}
+
+// match 判断 rel 是否匹配 section 的 glob. 不含 '/' 的 glob 匹配任意目录下的文件名,
+// 含 '/' 的 glob 相对于 .editorconfig 所在目录
+func match(glob, rel string) bool {
+	if strings.Contains(glob, "/") {
+		glob = strings.TrimPrefix(glob, "/")
+	} else {
+		glob = "**/" + glob
+	}
+	re, ranges, err := compile(glob)
+	if err != nil {
+		return false
+	}
+	m := re.FindStringSubmatch(rel)
+	if m == nil {
+		return false
+	}
+	for i, r := range ranges {
+		n, err := strconv.Atoi(m[i+1])
+		if err != nil || n < r[0] || n > r[1] {
+			return false
+		}
+	}
+	return true
+}
+
+var numRange = regexp.MustCompile(`^([+-]?\d+)\.\.([+-]?\d+)$`)
+
+// compile 把 glob 转换为正则表达式, {n1..n2} 转换为捕获组, 由调用方检查数值范围
+func compile(glob string) (*regexp.Regexp, [][2]int, error) {
+	var sb strings.Builder
+	var ranges [][2]int
+	braces := 0
+	sb.WriteString("^")
+	for i := 0; i < len(glob); i++ {
+		c := glob[i]
+		switch c {
+		case '\\':
+			if i+1 < len(glob) {
+				i++
+				sb.WriteString(regexp.QuoteMeta(string(glob[i])))
+			}
+		case '*':
+			if i+1 < len(glob) && glob[i+1] == '*' {
+				i++
+				if i+1 < len(glob) && glob[i+1] == '/' {
+					// "**/" 也匹配零层目录
+					i++
+					sb.WriteString("(?:.*/)?")
+				} else {
+					sb.WriteString(".*")
+				}
+			} else {
+				sb.WriteString("[^/]*")
+			}
+		case '?':
+			sb.WriteString("[^/]")
+		case '[':
+			end := strings.IndexByte(glob[i+1:], ']')
+			if end < 0 {
+				sb.WriteString(`\[`)
+				continue
+			}
+			class := glob[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+			sb.WriteString("[" + strings.ReplaceAll(class, `\`, `\\`) + "]")
+			i += end + 1
+		case '{':
+			end := strings.IndexByte(glob[i+1:], '}')
+			if end >= 0 {
+				if m := numRange.FindStringSubmatch(glob[i+1 : i+1+end]); m != nil {
+					lo, _ := strconv.Atoi(m[1])
+					hi, _ := strconv.Atoi(m[2])
+					ranges = append(ranges, [2]int{min(lo, hi), max(lo, hi)})
+					sb.WriteString(`([+-]?\d+)`)
+					i += end + 1
+					continue
+				}
+				if !strings.Contains(glob[i+1:i+1+end], ",") && !strings.Contains(glob[i+1:i+1+end], "{") {
+					// 没有逗号的花括号按字面匹配
+					sb.WriteString(regexp.QuoteMeta(glob[i : i+2+end]))
+					i += end + 1
+					continue
+				}
+			}
+			braces++
+			sb.WriteString("(?:")
+		case '}':
+			if braces > 0 {
+				braces--
+				sb.WriteString(")")
+			} else {
+				sb.WriteString(`\}`)
+			}
+		case ',':
+			if braces > 0 {
+				sb.WriteString("|")
+			} else {
+				sb.WriteString(",")
+			}
+		default:
+			sb.WriteString(regexp.QuoteMeta(string(c)))
+		}
+	}
+	if braces > 0 {
+		return nil, nil, fmt.Errorf("unbalanced braces in %q", glob)
+	}
+	sb.WriteString("$")
+	re, err := regexp.Compile(sb.String())
+	return re, ranges, err
+}
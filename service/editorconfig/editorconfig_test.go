@@ -0,0 +1,187 @@
+package editorconfig
+
+import (
+	"maps"
+	"remdit-server/service/textenc"
+	"remdit-server/service/textfmt"
+	"strings"
+	"testing"
+)
+
+func TestMatch(t *testing.T) {
+	tests := []struct {
+		glob, path string
+		want       bool
+	}{
+		{"*", "a.txt", true},
+		{"*", "dir/a.txt", true},
+		{"*.md", "docs/x.md", true},
+		{"*.md", "x.mdx", false},
+		{"Makefile", "sub/Makefile", true},
+		{"docs/*.md", "docs/a.md", true},
+		{"docs/*.md", "docs/sub/a.md", false},
+		{"docs/*.md", "x/docs/a.md", false},
+		{"/docs/*.md", "docs/a.md", true},
+		{"docs/**/*.md", "docs/a.md", true},
+		{"docs/**/*.md", "docs/a/b/c.md", true},
+		{"docs/**", "docs/a/b", true},
+		{"*.{js,ts}", "src/a.ts", true},
+		{"*.{js,ts}", "a.go", false},
+		{"{a,b/c}.txt", "b/c.txt", true},
+		{"file{1..3}.txt", "file2.txt", true},
+		{"file{1..3}.txt", "file4.txt", false},
+		{"file{3..1}.txt", "file1.txt", true},
+		{"file{-1..1}.txt", "file-1.txt", true},
+		{"file{1..3}.txt", "filex.txt", false},
+		{"[abc].txt", "b.txt", true},
+		{"[abc].txt", "d.txt", false},
+		{"[!abc].txt", "d.txt", true},
+		{"[!abc].txt", "a.txt", false},
+		{"[a-c].txt", "c.txt", true},
+		{"?.txt", "a.txt", true},
+		{"?.txt", "ab.txt", false},
+		{"?.txt", "/.txt", false},
+		{"{single}.txt", "{single}.txt", true},
+		{`a\*b`, "a*b", true},
+		{`a\*b`, "axb", false},
+		{"a.txt", "a_txt", false},
+		{"[unclosed", "[unclosed", true},
+		{"{a,b", "a", false},
+	}
+	for _, tt := range tests {
+		if got := match(tt.glob, tt.path); got != tt.want {
+			t.Errorf("match(%q, %q) = %v, want %v", tt.glob, tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestCompileUnbalanced(t *testing.T) {
+	if _, _, err := compile("*.{js,ts"); err == nil {
+		t.Fatal("compile accepted unbalanced braces")
+	}
+}
+
+func TestParse(t *testing.T) {
+	f, err := Parse([]byte("\ufeffroot = TRUE\n# comment\n; comment\n\n[*]\nIndent_Style = Space\ncustom = MixedCase\n[*.go]\nindent_style=tab\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !f.Root {
+		t.Error("root = TRUE was not parsed")
+	}
+	if len(f.sections) != 2 {
+		t.Fatalf("got %d sections, want 2", len(f.sections))
+	}
+	want := Properties{"indent_style": "space", "custom": "MixedCase"}
+	if !maps.Equal(f.sections[0].props, want) {
+		t.Errorf("section [*] = %v, want %v", f.sections[0].props, want)
+	}
+
+	for _, bad := range []string{"[]\n", "[*\n", "[*]\nnovalue\n"} {
+		if _, err := Parse([]byte(bad)); err == nil || !strings.Contains(err.Error(), "line") {
+			t.Errorf("Parse(%q) err = %v, want a line error", bad, err)
+		}
+	}
+}
+
+func mustParse(t *testing.T, s string) *File {
+	t.Helper()
+	f, err := Parse([]byte(s))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func TestResolve(t *testing.T) {
+	rootFile := mustParse(t, "root = true\n[*]\nindent_style = space\nindent_size = 2\nend_of_line = lf\n[*.go]\nindent_style = tab\n")
+	sub := mustParse(t, "[*]\nend_of_line = crlf\nindent_size = unset\n")
+	nested := mustParse(t, "root = true\n[*]\ncharset = latin1\n")
+	sources := []Source{
+		{Dir: "app/vendor", File: nested},
+		{Dir: "app", File: sub},
+		{Dir: ".", File: rootFile},
+	}
+	tests := []struct {
+		path string
+		want Properties
+	}{
+		{"a.txt", Properties{"indent_style": "space", "indent_size": "2", "tab_width": "2", "end_of_line": "lf"}},
+		{"main.go", Properties{"indent_style": "tab", "indent_size": "2", "tab_width": "2", "end_of_line": "lf"}},
+		{"app/a.txt", Properties{"indent_style": "space", "end_of_line": "crlf"}},
+		{"app/vendor/x.txt", Properties{"charset": "latin1"}},
+		{"application/a.txt", Properties{"indent_style": "space", "indent_size": "2", "tab_width": "2", "end_of_line": "lf"}},
+	}
+	for _, tt := range tests {
+		if got := Resolve(sources, tt.path); !maps.Equal(got, tt.want) {
+			t.Errorf("Resolve(%q) = %v, want %v", tt.path, got, tt.want)
+		}
+	}
+
+	// tab 缩进未指定 indent_size 时使用 tab_width
+	tabOnly := []Source{{Dir: ".", File: mustParse(t, "[*]\nindent_style = tab\ntab_width = 8\n")}}
+	want := Properties{"indent_style": "tab", "indent_size": "8", "tab_width": "8"}
+	if got := Resolve(tabOnly, "a.c"); !maps.Equal(got, want) {
+		t.Errorf("Resolve with tab_width = %v, want %v", got, want)
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	got := Normalize(map[string]string{" Charset ": "UTF-8", "indent_size": "unset", "custom": "Keep", "": "x", "empty": ""})
+	want := Properties{"charset": "utf-8", "custom": "Keep"}
+	if !maps.Equal(got, want) {
+		t.Fatalf("Normalize = %v, want %v", got, want)
+	}
+}
+
+func TestPropertiesEncoding(t *testing.T) {
+	detected := textenc.Encoding{Name: textenc.ShiftJIS}
+	tests := []struct {
+		charset string
+		want    textenc.Encoding
+	}{
+		{"utf-8", textenc.Encoding{Name: textenc.UTF8}},
+		{"utf-8-bom", textenc.Encoding{Name: textenc.UTF8, BOM: true}},
+		{"utf-16le", textenc.Encoding{Name: textenc.UTF16LE, BOM: true}},
+		{"utf-16be", textenc.Encoding{Name: textenc.UTF16BE, BOM: true}},
+		{"latin1", textenc.Encoding{Name: textenc.Windows1252}},
+		{"klingon", detected},
+		{"", detected},
+	}
+	for _, tt := range tests {
+		if got := (Properties{"charset": tt.charset}).Encoding(detected); got != tt.want {
+			t.Errorf("charset %q: Encoding = %+v, want %+v", tt.charset, got, tt.want)
+		}
+	}
+}
+
+func TestPropertiesFormat(t *testing.T) {
+	detected := textfmt.Format{LineEnding: textfmt.LF, FinalNewline: true, Indent: textfmt.IndentSpace, IndentSize: 2}
+	tests := []struct {
+		name  string
+		props Properties
+		want  textfmt.Format
+	}{
+		{"empty keeps detected", Properties{}, detected},
+		{
+			"tab uses tab_width",
+			Properties{"indent_style": "tab", "tab_width": "8", "indent_size": "3"},
+			textfmt.Format{LineEnding: textfmt.LF, FinalNewline: true, Indent: textfmt.IndentTab, IndentSize: 8, ConvertIndent: true},
+		},
+		{
+			"space uses indent_size",
+			Properties{"indent_style": "space", "indent_size": "4", "end_of_line": "crlf", "insert_final_newline": "false", "trim_trailing_whitespace": "true"},
+			textfmt.Format{LineEnding: textfmt.CRLF, Indent: textfmt.IndentSpace, IndentSize: 4, ConvertIndent: true, TrimTrailingWhitespace: true},
+		},
+		{
+			"invalid values are ignored",
+			Properties{"end_of_line": "nel", "indent_size": "0", "indent_style": "space", "insert_final_newline": "maybe"},
+			textfmt.Format{LineEnding: textfmt.LF, FinalNewline: true, Indent: textfmt.IndentSpace, IndentSize: 2, ConvertIndent: true},
+		},
+	}
+	for _, tt := range tests {
+		if got := tt.props.Format(detected); got != tt.want {
+			t.Errorf("%s: Format = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
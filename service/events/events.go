@@ -0,0 +1,151 @@
+package events
+
+import (
+	"log/slog"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type Type string
+
+const (
+	SessionCreated    Type = "session.created"
+	FileAdded         Type = "file.added"
+	CLIConnected      Type = "cli.connected"
+	CLIDisconnected   Type = "cli.disconnected"
+	ParticipantJoined Type = "participant.joined"
+	ParticipantLeft   Type = "participant.left"
+	SaveRequested     Type = "save.requested"
+	SaveSucceeded     Type = "save.succeeded"
+	SaveFailed        Type = "save.failed"
+	SessionFinished   Type = "session.finished"
+	SessionCleanedUp  Type = "session.cleaned_up"
+)
+
+var AllTypes = []Type{
+	SessionCreated, FileAdded, CLIConnected, CLIDisconnected,
+	ParticipantJoined, ParticipantLeft,
+	SaveRequested, SaveSucceeded, SaveFailed,
+	SessionFinished, SessionCleanedUp,
+}
+
+type Event struct {
+	ID        uint64         `json:"id"`
+	Type      Type           `json:"type"`
+	SessionID string         `json:"sessionid"`
+	Time      time.Time      `json:"time"`
+	Data      map[string]any `json:"data,omitempty"`
+}
+
+// Filter 为空字段表示不过滤
+type Filter struct {
+	SessionID string
+	Types     []Type
+}
+
+func (f Filter) Match(e Event) bool {
+	if f.SessionID != "" && f.SessionID != e.SessionID {
+		return false
+	}
+	return len(f.Types) == 0 || slices.Contains(f.Types, e.Type)
+}
+
+type Subscription struct {
+	bus     *Bus
+	filter  Filter
+	ch      chan Event
+	once    sync.Once
+	dropped atomic.Uint64
+}
+
+func (s *Subscription) C() <-chan Event {
+	return s.ch
+}
+
+// Dropped 返回因订阅者处理过慢而丢弃的事件数
+func (s *Subscription) Dropped() uint64 {
+	return s.dropped.Load()
+}
+
+func (s *Subscription) Close() {
+	s.bus.unsubscribe(s)
+}
+
+// Bus 是进程内的事件总线, 发布永不阻塞, 慢订阅者会丢失事件
+type Bus struct {
+	mu   sync.RWMutex
+	subs map[*Subscription]struct{}
+	seq  atomic.Uint64
+}
+
+func NewBus() *Bus {
+	return &Bus{subs: make(map[*Subscription]struct{})}
+}
+
+var defaultBus = NewBus()
+
+func Default() *Bus {
+	return defaultBus
+}
+
+func (b *Bus) Subscribe(filter Filter, buffer int) *Subscription {
+	s := &Subscription{bus: b, filter: filter, ch: make(chan Event, buffer)}
+	b.mu.Lock()
+	b.subs[s] = struct{}{}
+	b.mu.Unlock()
+	return s
+}
+
+func (b *Bus) unsubscribe(s *Subscription) {
+	b.mu.Lock()
+	delete(b.subs, s)
+	b.mu.Unlock()
+	s.once.Do(func() { close(s.ch) })
+}
+
+func (b *Bus) Publish(t Type, sessionID string, data map[string]any) {
+	e := Event{
+		ID:        b.seq.Add(1),
+		Type:      t,
+		SessionID: sessionID,
+		Time:      time.Now(),
+		Data:      data,
+	}
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	for s := range b.subs {
+		if !s.filter.Match(e) {
+			continue
+		}
+		select {
+		case s.ch <- e:
+		default:
+			if s.dropped.Add(1) == 1 {
+				slog.Warn("Event subscriber too slow, dropping events", "type", e.Type)
+			}
+		}
+	}
+}
+
+// Close 关闭所有订阅
+func (b *Bus) Close() {
+	b.mu.RLock()
+	subs := make([]*Subscription, 0, len(b.subs))
+	for s := range b.subs {
+		subs = append(subs, s)
+	}
+	b.mu.RUnlock()
+	for _, s := range subs {
+		s.Close()
+	}
+}
+
+func Publish(t Type, sessionID string, data map[string]any) {
+	defaultBus.Publish(t, sessionID, data)
+}
+
+func Subscribe(filter Filter, buffer int) *Subscription {
+	return defaultBus.Subscribe(filter, buffer)
+}
@@ -0,0 +1,182 @@
+package quota
+
+import (
+	"errors"
+	"fmt"
+	"remdit-server/service/apikey"
+	"sort"
+	"sync"
+	"time"
+)
+
+var (
+	ErrConcurrentSessions = errors.New("concurrent session quota exceeded")
+	ErrDailySessions      = errors.New("daily session quota exceeded")
+	ErrStorage            = errors.New("storage quota exceeded")
+	ErrFileSize           = errors.New("file size quota exceeded")
+)
+
+// IsTooLarge 判断配额错误是否应返回 413 而不是 429
+func IsTooLarge(err error) bool {
+	return errors.Is(err, ErrStorage) || errors.Is(err, ErrFileSize)
+}
+
+// Usage 是单个 key 当前的资源用量
+type Usage struct {
+	KeyID          string       `json:"key_id"`
+	KeyName        string       `json:"key_name"`
+	ActiveSessions int          `json:"active_sessions"`
+	SessionsToday  int          `json:"sessions_today"`
+	StoredBytes    int64        `json:"stored_bytes"`
+	Quota          apikey.Quota `json:"quota"`
+}
+
+type session struct {
+	keyID string
+	bytes int64
+}
+
+// Tracker 在内存中记录每个 key 的会话和存储用量
+type Tracker struct {
+	mu       sync.Mutex
+	usage    map[string]*Usage
+	sessions map[string]*session
+	day      string
+}
+
+var defaultTracker = NewTracker()
+
+func Default() *Tracker {
+	return defaultTracker
+}
+
+func NewTracker() *Tracker {
+	return &Tracker{
+		usage:    make(map[string]*Usage),
+		sessions: make(map[string]*session),
+	}
+}
+
+func (t *Tracker) rollDay(now time.Time) {
+	day := now.UTC().Format(time.DateOnly)
+	if day == t.day {
+		return
+	}
+	t.day = day
+	for _, u := range t.usage {
+		u.SessionsToday = 0
+	}
+}
+
+// Acquire 为 key 登记一个新会话, size 是会话中所有文件的总大小, largest 是其中最大的文件.
+// 超出配额时返回错误且不做任何记录
+func (t *Tracker) Acquire(key *apikey.Key, sessionID string, size, largest int64) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.rollDay(time.Now())
+
+	u, ok := t.usage[key.ID]
+	if !ok {
+		u = &Usage{KeyID: key.ID}
+		t.usage[key.ID] = u
+	}
+	u.KeyName = key.Name
+	u.Quota = key.Quota
+
+	q := key.Quota
+	switch {
+	case q.MaxFileSize > 0 && largest > q.MaxFileSize:
+		return fmt.Errorf("%w: %d > %d bytes", ErrFileSize, largest, q.MaxFileSize)
+	case q.MaxStorageBytes > 0 && u.StoredBytes+size > q.MaxStorageBytes:
+		return fmt.Errorf("%w: %d + %d > %d bytes", ErrStorage, u.StoredBytes, size, q.MaxStorageBytes)
+	case q.MaxConcurrentSessions > 0 && u.ActiveSessions >= q.MaxConcurrentSessions:
+		return fmt.Errorf("%w: %d active sessions", ErrConcurrentSessions, u.ActiveSessions)
+	case q.SessionsPerDay > 0 && u.SessionsToday >= q.SessionsPerDay:
+		return fmt.Errorf("%w: %d sessions today", ErrDailySessions, u.SessionsToday)
+	}
+
+	u.ActiveSessions++
+	u.SessionsToday++
+	u.StoredBytes += size
+	t.sessions[sessionID] = &session{keyID: key.ID, bytes: size}
+	return nil
+}
+
+// Resize 更新会话占用的字节数, 用于保存文件时. file 是被保存的文件的新大小
+func (t *Tracker) Resize(sessionID string, size, file int64) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s, ok := t.sessions[sessionID]
+	if !ok {
+		return nil
+	}
+	u := t.usage[s.keyID]
+	q := u.Quota
+	switch {
+	case q.MaxFileSize > 0 && file > q.MaxFileSize:
+		return fmt.Errorf("%w: %d > %d bytes", ErrFileSize, file, q.MaxFileSize)
+	case q.MaxStorageBytes > 0 && u.StoredBytes-s.bytes+size > q.MaxStorageBytes:
+		return fmt.Errorf("%w: %d bytes would be stored", ErrStorage, u.StoredBytes-s.bytes+size)
+	}
+	u.StoredBytes += size - s.bytes
+	s.bytes = size
+	return nil
+}
+
+// Restore 把会话占用的字节数恢复为 size, 不检查配额, 用于撤销保存失败时的 Resize
+func (t *Tracker) Restore(sessionID string, size int64) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s, ok := t.sessions[sessionID]
+	if !ok {
+		return
+	}
+	if u, ok := t.usage[s.keyID]; ok {
+		u.StoredBytes += size - s.bytes
+	}
+	s.bytes = size
+}
+
+// Release 释放会话占用的配额, 可重复调用
+func (t *Tracker) Release(sessionID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	s, ok := t.sessions[sessionID]
+	if !ok {
+		return
+	}
+	delete(t.sessions, sessionID)
+	if u, ok := t.usage[s.keyID]; ok {
+		u.ActiveSessions--
+		u.StoredBytes -= s.bytes
+	}
+}
+
+// Snapshot 返回所有 key 的用量副本
+func (t *Tracker) Snapshot() []Usage {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.rollDay(time.Now())
+	result := make([]Usage, 0, len(t.usage))
+	for _, u := range t.usage {
+		result = append(result, *u)
+	}
+	sort.Slice(result, func(i, j int) bool { return result[i].KeyName < result[j].KeyName })
+	return result
+}
+
+func Acquire(key *apikey.Key, sessionID string, size, largest int64) error {
+	return defaultTracker.Acquire(key, sessionID, size, largest)
+}
+
+func Resize(sessionID string, size, file int64) error {
+	return defaultTracker.Resize(sessionID, size, file)
+}
+
+func Restore(sessionID string, size int64) {
+	defaultTracker.Restore(sessionID, size)
+}
+
+func Release(sessionID string) {
+	defaultTracker.Release(sessionID)
+}
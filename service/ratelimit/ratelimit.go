@@ -0,0 +1,102 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// Limit 描述一个令牌桶: 每分钟补充 PerMinute 个令牌, 最多积累 Burst 个
+type Limit struct {
+	PerMinute int
+	Burst     int
+}
+
+func (l Limit) perSecond() float64 {
+	return float64(l.PerMinute) / 60
+}
+
+// Result 是一次 Take 的结果, 用于填充 RateLimit-* 响应头
+type Result struct {
+	Allowed    bool
+	Limit      int
+	Remaining  int
+	Reset      time.Duration // 令牌桶恢复满额所需时间
+	RetryAfter time.Duration // 被拒绝时距离下一个令牌的时间
+}
+
+type bucket struct {
+	tokens   float64
+	last     time.Time
+	rate     float64
+	capacity float64
+}
+
+// Store 在内存中保存所有策略的令牌桶
+type Store struct {
+	mu      sync.Mutex
+	buckets map[string]*bucket
+}
+
+func NewStore() *Store {
+	return &Store{buckets: make(map[string]*bucket)}
+}
+
+// Take 从 key 对应的桶中取一个令牌
+func (s *Store) Take(key string, limit Limit) Result {
+	now := time.Now()
+	rate := limit.perSecond()
+	capacity := float64(max(limit.Burst, 1))
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucket{tokens: capacity, last: now}
+		s.buckets[key] = b
+	}
+	b.tokens = math.Min(capacity, b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last, b.rate, b.capacity = now, rate, capacity
+
+	res := Result{Limit: int(capacity)}
+	if b.tokens >= 1 {
+		b.tokens--
+		res.Allowed = true
+	} else if rate > 0 {
+		res.RetryAfter = time.Duration((1 - b.tokens) / rate * float64(time.Second))
+	} else {
+		res.RetryAfter = time.Minute
+	}
+	res.Remaining = int(b.tokens)
+	if rate > 0 {
+		res.Reset = time.Duration((capacity - b.tokens) / rate * float64(time.Second))
+	}
+	return res
+}
+
+// sweep 删除已经恢复满额的桶, 它们与新建的桶等价
+func (s *Store) sweep() {
+	now := time.Now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for key, b := range s.buckets {
+		if b.tokens+now.Sub(b.last).Seconds()*b.rate >= b.capacity {
+			delete(s.buckets, key)
+		}
+	}
+}
+
+// StartSweeper 定期清理已恢复满额的桶, 直到 ctx 结束
+func (s *Store) StartSweeper(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.sweep()
+		}
+	}
+}
@@ -0,0 +1,248 @@
+package resumable
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
+	"log/slog"
+	"remdit-server/service/crypt"
+	"remdit-server/service/stors/blobstor"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+var (
+	ErrOffsetMismatch = errors.New("upload offset does not match")
+	ErrTooLarge       = errors.New("chunk exceeds the declared upload length")
+	ErrChecksum       = errors.New("checksum mismatch")
+	ErrIncomplete     = errors.New("upload is not complete")
+	ErrGone           = errors.New("upload no longer exists")
+	ErrTooManyUploads = errors.New("too many unfinished uploads")
+)
+
+// Upload 是一个可续传的上传, 每个分块单独保存在 blobstor 的 <id>/<序号> 下,
+// 完成并校验通过后才由调用方创建会话
+type Upload struct {
+	ID       string
+	Length   int64
+	Checksum []byte // 整个文件的 SHA-256
+	// Metadata 是创建时提供的元数据, 由调用方解释
+	Metadata  map[string]string
+	CreatedAt time.Time
+	// Owner 是创建上传的调用方身份, 例如 API key 的 ID, 为空表示不限制
+	Owner string
+	// Client 是计算未完成上传数量的维度, 例如 API key 或 IP
+	Client string
+
+	mu        sync.Mutex
+	offset    int64
+	chunks    []string
+	digest    hash.Hash
+	dataKey   *crypt.DataKey
+	expiresAt atomic.Int64
+	removed   bool
+}
+
+func (u *Upload) Offset() int64 {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	return u.offset
+}
+
+// ExpiresAt 不需要持有 u.mu, Store 在持有自身的锁时也会调用它
+func (u *Upload) ExpiresAt() time.Time {
+	return time.Unix(0, u.expiresAt.Load())
+}
+
+type Store struct {
+	mu      sync.Mutex
+	uploads map[string]*Upload
+}
+
+var defaultStore = NewStore()
+
+func Default() *Store {
+	return defaultStore
+}
+
+func NewStore() *Store {
+	return &Store{uploads: make(map[string]*Upload)}
+}
+
+// Create 登记一个新的上传, ttl 是上传在没有新分块时保留的时间.
+// 每个上传最多占用 length 字节的存储, client 已有 maxPending 个未完成的上传时返回 ErrTooManyUploads
+func (s *Store) Create(length int64, checksum []byte, metadata map[string]string, owner, client string, maxPending int, ttl time.Duration) (*Upload, error) {
+	now := time.Now()
+	u := &Upload{
+		ID:        uuid.New().String(),
+		Length:    length,
+		Checksum:  checksum,
+		Metadata:  metadata,
+		CreatedAt: now,
+		Owner:     owner,
+		Client:    client,
+		digest:    sha256.New(),
+	}
+	u.expiresAt.Store(now.Add(ttl).UnixNano())
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	pending := 0
+	for _, other := range s.uploads {
+		if other.Client == client && now.Before(other.ExpiresAt()) {
+			pending++
+		}
+	}
+	if pending >= maxPending {
+		return nil, fmt.Errorf("%w: %d", ErrTooManyUploads, pending)
+	}
+	if w := crypt.Default(); w != nil {
+		u.dataKey = w.NewDataKey()
+	}
+	s.uploads[u.ID] = u
+	return u, nil
+}
+
+// Get 返回未过期的上传, 不存在时返回 nil
+func (s *Store) Get(id string) *Upload {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	u := s.uploads[id]
+	if u == nil || time.Now().After(u.ExpiresAt()) {
+		return nil
+	}
+	return u
+}
+
+// Append 在 offset 处追加一个分块, chunkSum 不为空时先校验分块的 SHA-256, 返回新的偏移
+func (s *Store) Append(ctx context.Context, u *Upload, offset int64, data, chunkSum []byte, ttl time.Duration) (int64, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.removed {
+		return u.offset, ErrGone
+	}
+	if offset != u.offset {
+		return u.offset, ErrOffsetMismatch
+	}
+	if u.offset+int64(len(data)) > u.Length {
+		return u.offset, ErrTooLarge
+	}
+	if chunkSum != nil {
+		sum := sha256.Sum256(data)
+		if !bytes.Equal(sum[:], chunkSum) {
+			return u.offset, ErrChecksum
+		}
+	}
+	if len(data) == 0 {
+		return u.offset, nil
+	}
+	key := fmt.Sprintf("%s/%06d", u.ID, len(u.chunks))
+	stored := data
+	if u.dataKey != nil {
+		var err error
+		if stored, err = u.dataKey.Encrypt(data, []byte(key)); err != nil {
+			return u.offset, err
+		}
+	}
+	if err := blobstor.Default().Put(ctx, key, stored); err != nil {
+		return u.offset, err
+	}
+	u.digest.Write(data)
+	u.chunks = append(u.chunks, key)
+	u.offset += int64(len(data))
+	u.expiresAt.Store(time.Now().Add(ttl).UnixNano())
+	return u.offset, nil
+}
+
+// Complete 校验整个文件的 SHA-256 并返回拼接后的内容. 上传随即从 Store 中移除,
+// 校验失败时也不再保留
+func (s *Store) Complete(ctx context.Context, u *Upload) ([]byte, error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	if u.removed {
+		return nil, ErrGone
+	}
+	if u.offset != u.Length {
+		return nil, ErrIncomplete
+	}
+	defer s.remove(u)
+	if !bytes.Equal(u.digest.Sum(nil), u.Checksum) {
+		return nil, ErrChecksum
+	}
+	data := make([]byte, 0, u.Length)
+	for _, key := range u.chunks {
+		chunk, err := blobstor.Default().Get(ctx, key)
+		if err != nil {
+			return nil, err
+		}
+		if u.dataKey != nil {
+			if chunk, err = u.dataKey.Decrypt(chunk, []byte(key)); err != nil {
+				return nil, err
+			}
+		}
+		data = append(data, chunk...)
+	}
+	return data, nil
+}
+
+// Remove 取消上传并删除已保存的分块
+func (s *Store) Remove(u *Upload) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	s.remove(u)
+}
+
+// remove 需要持有 u.mu
+func (s *Store) remove(u *Upload) {
+	if u.removed {
+		return
+	}
+	u.removed = true
+	s.mu.Lock()
+	delete(s.uploads, u.ID)
+	s.mu.Unlock()
+	if u.dataKey != nil {
+		u.dataKey.Shred()
+	}
+	for _, key := range u.chunks {
+		if err := blobstor.Default().Delete(context.Background(), key); err != nil {
+			slog.Warn("Failed to delete upload chunk", "uploadid", u.ID, "key", key, "err", err)
+		}
+	}
+	u.chunks = nil
+}
+
+// Sweep 删除过期的上传
+func (s *Store) Sweep(now time.Time) int {
+	s.mu.Lock()
+	var expired []*Upload
+	for _, u := range s.uploads {
+		if now.After(u.ExpiresAt()) {
+			expired = append(expired, u)
+		}
+	}
+	s.mu.Unlock()
+	for _, u := range expired {
+		slog.Info("Removing expired upload", "uploadid", u.ID, "offset", u.Offset(), "length", u.Length)
+		s.Remove(u)
+	}
+	return len(expired)
+}
+
+func (s *Store) StartSweeper(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			s.Sweep(now)
+		}
+	}
+}
@@ -0,0 +1,170 @@
+package resumable
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"os"
+	"remdit-server/config"
+	"remdit-server/service/crypt"
+	"remdit-server/service/stors/blobstor"
+	"testing"
+	"time"
+)
+
+func TestMain(m *testing.M) {
+	if err := blobstor.Init(&config.Config{Storage: config.StorageMemory}); err != nil {
+		panic(err)
+	}
+	os.Exit(m.Run())
+}
+
+func sum(data []byte) []byte {
+	s := sha256.Sum256(data)
+	return s[:]
+}
+
+func appendAll(t *testing.T, s *Store, u *Upload, chunks ...string) {
+	t.Helper()
+	var offset int64
+	for _, c := range chunks {
+		next, err := s.Append(context.Background(), u, offset, []byte(c), sum([]byte(c)), time.Hour)
+		if err != nil {
+			t.Fatalf("Append at %d: %v", offset, err)
+		}
+		offset = next
+	}
+}
+
+func TestComplete(t *testing.T) {
+	for _, encrypted := range []bool{false, true} {
+		key := ""
+		if encrypted {
+			key = base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{7}, 32))
+		}
+		if err := crypt.Init(&config.Config{EncryptionKey: key}); err != nil {
+			t.Fatal(err)
+		}
+		s := NewStore()
+		data := []byte("hello, resumable world")
+		u, err := s.Create(int64(len(data)), sum(data), nil, "", "c", 4, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		appendAll(t, s, u, "hello, ", "resumable", "", " world")
+		if len(u.chunks) != 3 {
+			t.Fatalf("stored %d chunks, want 3 (empty chunks are not stored)", len(u.chunks))
+		}
+		chunks := append([]string{}, u.chunks...)
+		if stored, _ := blobstor.Default().Get(context.Background(), chunks[0]); bytes.Equal(stored, []byte("hello, ")) == encrypted {
+			t.Errorf("encrypted=%v: stored chunk = %q", encrypted, stored)
+		}
+
+		got, err := s.Complete(context.Background(), u)
+		if err != nil {
+			t.Fatalf("encrypted=%v: Complete: %v", encrypted, err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Fatalf("encrypted=%v: Complete = %q, want %q", encrypted, got, data)
+		}
+		if s.Get(u.ID) != nil {
+			t.Error("upload is still registered after Complete")
+		}
+		for _, k := range chunks {
+			if _, err := blobstor.Default().Get(context.Background(), k); !errors.Is(err, blobstor.ErrNotFound) {
+				t.Errorf("chunk %s was not deleted: %v", k, err)
+			}
+		}
+		if _, err := s.Complete(context.Background(), u); !errors.Is(err, ErrGone) {
+			t.Errorf("second Complete: err = %v, want ErrGone", err)
+		}
+	}
+	crypt.Init(&config.Config{})
+}
+
+func TestAppendErrors(t *testing.T) {
+	s := NewStore()
+	ctx := context.Background()
+	u, err := s.Create(10, sum([]byte("0123456789")), nil, "", "c", 4, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name     string
+		offset   int64
+		data     string
+		chunkSum []byte
+		err      error
+	}{
+		{"wrong offset", 3, "345", nil, ErrOffsetMismatch},
+		{"too large", 0, "0123456789a", nil, ErrTooLarge},
+		{"bad chunk checksum", 0, "012", sum([]byte("xyz")), ErrChecksum},
+	}
+	for _, tt := range tests {
+		offset, err := s.Append(ctx, u, tt.offset, []byte(tt.data), tt.chunkSum, time.Hour)
+		if !errors.Is(err, tt.err) || offset != 0 {
+			t.Errorf("%s: Append = %d, %v, want 0, %v", tt.name, offset, err, tt.err)
+		}
+	}
+	if _, err := s.Complete(ctx, u); !errors.Is(err, ErrIncomplete) {
+		t.Fatalf("Complete before the last chunk: err = %v, want ErrIncomplete", err)
+	}
+	if s.Get(u.ID) == nil {
+		t.Fatal("an incomplete upload was removed by Complete")
+	}
+	// 没有分块校验和时也接受
+	if offset, err := s.Append(ctx, u, 0, []byte("0123456789"), nil, time.Hour); err != nil || offset != 10 {
+		t.Fatalf("Append = %d, %v, want 10, nil", offset, err)
+	}
+}
+
+func TestCompleteChecksumMismatch(t *testing.T) {
+	s := NewStore()
+	data := []byte("abc")
+	u, err := s.Create(3, sum([]byte("abd")), nil, "", "c", 4, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	appendAll(t, s, u, string(data))
+	if _, err := s.Complete(context.Background(), u); !errors.Is(err, ErrChecksum) {
+		t.Fatalf("Complete: err = %v, want ErrChecksum", err)
+	}
+	if s.Get(u.ID) != nil {
+		t.Error("upload is still registered after a checksum mismatch")
+	}
+	if _, err := s.Append(context.Background(), u, 3, []byte("x"), nil, time.Hour); !errors.Is(err, ErrGone) {
+		t.Errorf("Append after removal: err = %v, want ErrGone", err)
+	}
+}
+
+func TestCreatePendingLimit(t *testing.T) {
+	s := NewStore()
+	for i := 0; i < 2; i++ {
+		if _, err := s.Create(1, nil, nil, "", "a", 2, time.Hour); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := s.Create(1, nil, nil, "", "a", 2, time.Hour); !errors.Is(err, ErrTooManyUploads) {
+		t.Fatalf("third upload: err = %v, want ErrTooManyUploads", err)
+	}
+	if _, err := s.Create(1, nil, nil, "", "b", 2, time.Hour); err != nil {
+		t.Fatalf("another client: %v", err)
+	}
+
+	// 过期但尚未清理的上传不占用名额
+	expired, err := s.Create(1, nil, nil, "", "c", 1, -time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Create(1, nil, nil, "", "c", 1, time.Hour); err != nil {
+		t.Fatalf("after expiry: %v", err)
+	}
+	if s.Get(expired.ID) != nil {
+		t.Error("Get returned an expired upload")
+	}
+	if n := s.Sweep(time.Now()); n != 1 {
+		t.Errorf("Sweep removed %d uploads, want 1", n)
+	}
+}
@@ -0,0 +1,45 @@
+package blobstor
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"remdit-server/config"
+)
+
+var ErrNotFound = errors.New("blob not found")
+
+// Store 保存会话文件的内容, key 是形如 <sessionid>/<fileid> 的相对路径; 可续传上传的分块使用 <uploadid>/<序号>
+type Store interface {
+	Put(ctx context.Context, key string, data []byte) error
+	Get(ctx context.Context, key string) ([]byte, error)
+	Delete(ctx context.Context, key string) error
+}
+
+var defaultStore Store
+
+func Default() Store {
+	return defaultStore
+}
+
+// Init 根据配置创建默认存储, 只应在启动时调用一次
+func Init(cfg *config.Config) error {
+	store, err := New(cfg)
+	if err != nil {
+		return err
+	}
+	defaultStore = store
+	return nil
+}
+
+func New(cfg *config.Config) (Store, error) {
+	switch cfg.Storage {
+	case config.StorageLocal:
+		return NewLocal(cfg.UploadsDir), nil
+	case config.StorageMemory:
+		return NewMemory(), nil
+	case config.StorageS3:
+		return NewS3(cfg.S3)
+	}
+	return nil, fmt.Errorf("unknown storage backend %q", cfg.Storage)
+}
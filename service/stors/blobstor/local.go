@@ -0,0 +1,94 @@
+package blobstor
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"go.uber.org/multierr"
+)
+
+// Local 把内容保存在本地目录中
+type Local struct {
+	root string
+}
+
+var _ Store = (*Local)(nil)
+
+func NewLocal(root string) *Local {
+	return &Local{root: root}
+}
+
+func (s *Local) path(key string) (string, error) {
+	p := filepath.Join(s.root, filepath.FromSlash(key))
+	rel, err := filepath.Rel(s.root, p)
+	if err != nil || rel == "." || strings.HasPrefix(rel, "..") {
+		return "", fmt.Errorf("invalid blob key %q", key)
+	}
+	return p, nil
+}
+
+func (s *Local) Put(ctx context.Context, key string, data []byte) error {
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(p), 0755); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(p), ".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), p)
+}
+
+func (s *Local) Get(ctx context.Context, key string) ([]byte, error) {
+	p, err := s.path(key)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(p)
+	if os.IsNotExist(err) {
+		return nil, ErrNotFound
+	}
+	return data, err
+}
+
+// Delete 删除内容, 并清理因此变空的上级目录
+func (s *Local) Delete(ctx context.Context, key string) error {
+	p, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.RemoveAll(p); err != nil {
+		return fmt.Errorf("failed to remove file %s: %w", p, err)
+	}
+	var errs error
+	root := filepath.Clean(s.root)
+	for dir := filepath.Dir(p); dir != root && strings.HasPrefix(dir, root); dir = filepath.Dir(dir) {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			errs = multierr.Append(errs, fmt.Errorf("failed to read directory %s: %w", dir, err))
+			break
+		}
+		if len(entries) > 0 {
+			break
+		}
+		if err := os.Remove(dir); err != nil {
+			errs = multierr.Append(errs, fmt.Errorf("failed to remove empty directory %s: %w", dir, err))
+			break
+		}
+	}
+	return errs
+}
@@ -0,0 +1,43 @@
+package blobstor
+
+import (
+	"bytes"
+	"context"
+	"sync"
+)
+
+// Memory 只在内存中保存内容, 不会写入磁盘
+type Memory struct {
+	mu   sync.RWMutex
+	data map[string][]byte
+}
+
+var _ Store = (*Memory)(nil)
+
+func NewMemory() *Memory {
+	return &Memory{data: make(map[string][]byte)}
+}
+
+func (s *Memory) Put(ctx context.Context, key string, data []byte) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.data[key] = bytes.Clone(data)
+	return nil
+}
+
+func (s *Memory) Get(ctx context.Context, key string) ([]byte, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	data, ok := s.data[key]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return bytes.Clone(data), nil
+}
+
+func (s *Memory) Delete(ctx context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.data, key)
+	return nil
+}
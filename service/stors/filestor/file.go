@@ -2,75 +2,313 @@ package filestor
 
 import (
 	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"errors"
 	"fmt"
-	"os"
+	"remdit-server/service/crypt"
+	"remdit-server/service/editorconfig"
+	"remdit-server/service/stors/blobstor"
+	"remdit-server/service/textenc"
+	"remdit-server/service/textfmt"
+	"sort"
 	"sync"
-
-	"go.uber.org/multierr"
+	"sync/atomic"
+	"time"
 )
 
 type FileInfoStorage interface {
 	Save(ctx context.Context, fileID string, f File) error
 	Get(ctx context.Context, fileID string) File
 	Delete(ctx context.Context, fileID string) error
+	List(ctx context.Context) []File
+	// ListSession 返回会话中的所有文件, 按路径排序
+	ListSession(ctx context.Context, sessionID string) []File
+	// DeleteSession 删除会话中的所有文件
+	DeleteSession(ctx context.Context, sessionID string) error
+}
+
+// Origin 记录创建会话的请求来源
+type Origin struct {
+	KeyID   string `json:"key_id,omitempty"`
+	KeyName string `json:"key_name,omitempty"`
+	IP      string `json:"ip"`
 }
 
 type File interface {
 	ID() string
-	Path() string
+	// SessionID 是文件所属的会话, 会话的第一个文件的 ID 与会话 ID 相同
+	SessionID() string
+	// Key 是内容在 blobstor 中的 key
+	Key() string
+	// Name 和 Path 是客户端提供的文件名和相对路径经 SanitizePath 处理后的结果, 只用于展示,
+	// Path 使用 '/' 分隔
 	Name() string
+	Path() string
+	// Revision 在每次写入后加一
+	Revision() int64
+	Size() int64
+	CreatedAt() time.Time
+	Origin() Origin
+	// E2E 表示内容是浏览器端加密的密文, 服务端不解析
+	E2E() bool
+	// ExpiresAt 是会话的到期时间, 零值表示不限制
+	ExpiresAt() time.Time
+	SetExpiresAt(t time.Time)
+	// IsOwner 校验创建会话时返回的 owner token
+	IsOwner(token string) bool
+	// AllowedPaths 是客户端程序允许浏览器浏览和打开的路径模式
+	AllowedPaths() []string
+	// Binary 表示内容是二进制数据, 只能只读查看, ContentType 是识别出的类型
+	Binary() bool
+	ContentType() string
+	// Encoding 是内容的原始字符编码, 保存的内容始终使用该编码
+	Encoding() textenc.Encoding
+	SetEncoding(e textenc.Encoding)
+	// TextFormat 是内容的换行符, 结尾换行和缩进风格, 保存时按它规范化
+	TextFormat() textfmt.Format
+	SetTextFormat(tf textfmt.Format)
+	// EditorConfig 是为该文件解析出的 EditorConfig 属性, 保存时强制执行
+	EditorConfig() editorconfig.Properties
+	// EditorConfigSources 是创建会话时附带的 .editorconfig 文件, 用于之后打开的文件
+	EditorConfigSources() []editorconfig.Source
+	Read(ctx context.Context) ([]byte, error)
+	Write(ctx context.Context, data []byte) error
 	Remove() error
 }
 
 type fileImpl struct {
-	id         string
-	path       string
-	name       string
-	removeDirs []string
+	id        string
+	sessionID string
+	key       string
+	name      string
+	path      string
+	revision  atomic.Int64
+	createdAt time.Time
+	origin    Origin
+	store     blobstor.Store
+	size      atomic.Int64
+	dataKey   *crypt.DataKey
+	e2e       bool
+	expiresAt atomic.Int64
+	ownerHash [32]byte
+	allowed   []string
+	encoding  atomic.Pointer[textenc.Encoding]
+	format    atomic.Pointer[textfmt.Format]
+	ctype     string
+	ecProps   editorconfig.Properties
+	ecSources []editorconfig.Source
+}
+
+type Option func(f *fileImpl)
+
+// WithEncryption 使用 w 包装的独立数据密钥加密文件内容
+func WithEncryption(w *crypt.KeyWrapper) Option {
+	return func(f *fileImpl) {
+		if w != nil {
+			f.dataKey = w.NewDataKey()
+		}
+	}
+}
+
+// WithE2E 标记文件内容为端到端加密的密文
+func WithE2E() Option {
+	return func(f *fileImpl) {
+		f.e2e = true
+	}
+}
+
+// WithSession 把文件加入 sessionID 对应的会话, path 是文件在会话中的相对路径
+func WithSession(sessionID, path string) Option {
+	return func(f *fileImpl) {
+		f.sessionID, f.path = sessionID, path
+	}
+}
+
+// WithAllowedPaths 设置浏览器可以通过客户端程序浏览和打开的路径模式
+func WithAllowedPaths(patterns []string) Option {
+	return func(f *fileImpl) {
+		f.allowed = patterns
+	}
+}
+
+// WithBinary 标记文件为二进制数据
+func WithBinary(contentType string) Option {
+	return func(f *fileImpl) {
+		f.ctype = contentType
+	}
+}
+
+// WithTextEncoding 记录内容的原始字符编码
+func WithTextEncoding(e textenc.Encoding) Option {
+	return func(f *fileImpl) {
+		f.SetEncoding(e)
+	}
+}
+
+// WithTextFormat 记录内容的换行符, 结尾换行和缩进风格
+func WithTextFormat(tf textfmt.Format) Option {
+	return func(f *fileImpl) {
+		f.SetTextFormat(tf)
+	}
+}
+
+// WithEditorConfig 设置文件的 EditorConfig 属性和会话附带的 .editorconfig 文件
+func WithEditorConfig(props editorconfig.Properties, sources []editorconfig.Source) Option {
+	return func(f *fileImpl) {
+		f.ecProps, f.ecSources = props, sources
+	}
+}
+
+// WithExpiry 设置会话的到期时间
+func WithExpiry(t time.Time) Option {
+	return func(f *fileImpl) {
+		f.SetExpiresAt(t)
+	}
+}
+
+// WithOwnerToken 只保存 token 的哈希, 用于之后校验会话所有者
+func WithOwnerToken(token string) Option {
+	return func(f *fileImpl) {
+		f.ownerHash = sha256.Sum256([]byte(token))
+	}
 }
 
 func (f *fileImpl) ID() string {
 	return f.id
 }
-func (f *fileImpl) Path() string {
-	return f.path
+func (f *fileImpl) SessionID() string {
+	return f.sessionID
+}
+func (f *fileImpl) Key() string {
+	return f.key
 }
 func (f *fileImpl) Name() string {
 	return f.name
 }
+func (f *fileImpl) Path() string {
+	return f.path
+}
+func (f *fileImpl) Revision() int64 {
+	return f.revision.Load()
+}
+func (f *fileImpl) Size() int64 {
+	return f.size.Load()
+}
+func (f *fileImpl) CreatedAt() time.Time {
+	return f.createdAt
+}
+func (f *fileImpl) Origin() Origin {
+	return f.origin
+}
+func (f *fileImpl) E2E() bool {
+	return f.e2e
+}
+func (f *fileImpl) ExpiresAt() time.Time {
+	if ns := f.expiresAt.Load(); ns != 0 {
+		return time.Unix(0, ns)
+	}
+	return time.Time{}
+}
+func (f *fileImpl) SetExpiresAt(t time.Time) {
+	if t.IsZero() {
+		f.expiresAt.Store(0)
+		return
+	}
+	f.expiresAt.Store(t.UnixNano())
+}
+func (f *fileImpl) AllowedPaths() []string {
+	return f.allowed
+}
+func (f *fileImpl) Binary() bool {
+	return f.ctype != ""
+}
+func (f *fileImpl) ContentType() string {
+	return f.ctype
+}
+func (f *fileImpl) Encoding() textenc.Encoding {
+	if e := f.encoding.Load(); e != nil {
+		return *e
+	}
+	return textenc.Default
+}
+func (f *fileImpl) SetEncoding(e textenc.Encoding) {
+	f.encoding.Store(&e)
+}
+func (f *fileImpl) TextFormat() textfmt.Format {
+	if tf := f.format.Load(); tf != nil {
+		return *tf
+	}
+	return textfmt.Default
+}
+func (f *fileImpl) SetTextFormat(tf textfmt.Format) {
+	f.format.Store(&tf)
+}
+func (f *fileImpl) EditorConfig() editorconfig.Properties {
+	return f.ecProps
+}
+func (f *fileImpl) EditorConfigSources() []editorconfig.Source {
+	return f.ecSources
+}
+func (f *fileImpl) IsOwner(token string) bool {
+	if token == "" || f.ownerHash == [32]byte{} {
+		return false
+	}
+	sum := sha256.Sum256([]byte(token))
+	return subtle.ConstantTimeCompare(sum[:], f.ownerHash[:]) == 1
+}
 
-func (f *fileImpl) Remove() error {
-	if f.path == "" {
-		return fmt.Errorf("file path is empty")
-	}
-	if err := os.RemoveAll(f.path); err != nil {
-		return fmt.Errorf("failed to remove file %s: %w", f.path, err)
-	}
-	var errs error
-	if len(f.removeDirs) > 0 {
-		for _, dir := range f.removeDirs {
-			entries, err := os.ReadDir(dir)
-			if err != nil {
-				errs = multierr.Append(errs, fmt.Errorf("failed to read directory %s: %w", dir, err))
-				continue
-			}
-			if len(entries) == 0 {
-				if err := os.Remove(dir); err != nil {
-					errs = multierr.Append(errs, fmt.Errorf("failed to remove empty directory %s: %w", dir, err))
-				}
-			}
+func (f *fileImpl) Read(ctx context.Context) ([]byte, error) {
+	data, err := f.store.Get(ctx, f.key)
+	if err != nil || f.dataKey == nil {
+		return data, err
+	}
+	return f.dataKey.Decrypt(data, []byte(f.key))
+}
+
+func (f *fileImpl) Write(ctx context.Context, data []byte) error {
+	stored := data
+	if f.dataKey != nil {
+		var err error
+		if stored, err = f.dataKey.Encrypt(data, []byte(f.key)); err != nil {
+			return err
 		}
 	}
-	return errs
+	if err := f.store.Put(ctx, f.key, stored); err != nil {
+		return err
+	}
+	f.size.Store(int64(len(data)))
+	f.revision.Add(1)
+	return nil
 }
 
-func NewFile(id, path, name string, removeDirs ...string) File {
-	return &fileImpl{
-		id:         id,
-		path:       path,
-		name:       name,
-		removeDirs: removeDirs,
+// Remove 先销毁数据密钥再删除内容, 即使删除失败残留的内容也无法解密
+func (f *fileImpl) Remove() error {
+	if f.key == "" {
+		return fmt.Errorf("file key is empty")
 	}
+	if f.dataKey != nil {
+		f.dataKey.Shred()
+	}
+	return f.store.Delete(context.Background(), f.key)
+}
+
+// NewFile 创建指向 store 中 key 的文件, 内容需通过 Write 写入
+func NewFile(store blobstor.Store, id, key, name string, origin Origin, opts ...Option) File {
+	f := &fileImpl{
+		id:        id,
+		sessionID: id,
+		key:       key,
+		name:      name,
+		path:      name,
+		createdAt: time.Now(),
+		origin:    origin,
+		store:     store,
+	}
+	for _, opt := range opts {
+		opt(f)
+	}
+	return f
 }
 
 type FileMemoryStorage struct {
@@ -114,12 +352,46 @@ func (s *FileMemoryStorage) Get(ctx context.Context, fileID string) File {
 
 func (s *FileMemoryStorage) Delete(ctx context.Context, fileID string) error {
 	s.mu.Lock()
-	defer s.mu.Unlock()
-	if file, exists := s.data[fileID]; exists {
-		file.Remove()
-		delete(s.data, fileID)
+	file, exists := s.data[fileID]
+	delete(s.data, fileID)
+	s.mu.Unlock()
+	if !exists {
+		return nil
 	}
-	return nil
+	return file.Remove()
+}
+
+func (s *FileMemoryStorage) List(ctx context.Context) []File {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	files := make([]File, 0, len(s.data))
+	for _, f := range s.data {
+		files = append(files, f)
+	}
+	return files
+}
+
+func (s *FileMemoryStorage) ListSession(ctx context.Context, sessionID string) []File {
+	s.mu.RLock()
+	files := make([]File, 0, 1)
+	for _, f := range s.data {
+		if f.SessionID() == sessionID {
+			files = append(files, f)
+		}
+	}
+	s.mu.RUnlock()
+	sort.Slice(files, func(i, j int) bool { return files[i].Path() < files[j].Path() })
+	return files
+}
+
+func (s *FileMemoryStorage) DeleteSession(ctx context.Context, sessionID string) error {
+	var errs []error
+	for _, f := range s.ListSession(ctx, sessionID) {
+		if err := s.Delete(ctx, f.ID()); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	return errors.Join(errs...)
 }
 
 func Save(ctx context.Context, fileID string, f File) error {
@@ -142,3 +414,21 @@ func Delete(ctx context.Context, fileID string) error {
 	}
 	return defaultStor.Delete(ctx, fileID)
 }
+
+func List(ctx context.Context) []File {
+	return defaultStor.List(ctx)
+}
+
+func ListSession(ctx context.Context, sessionID string) []File {
+	if sessionID == "" {
+		return nil
+	}
+	return defaultStor.ListSession(ctx, sessionID)
+}
+
+func DeleteSession(ctx context.Context, sessionID string) error {
+	if sessionID == "" {
+		return fmt.Errorf("session ID cannot be empty")
+	}
+	return defaultStor.DeleteSession(ctx, sessionID)
+}
@@ -0,0 +1,72 @@
+package filestor
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/unicode/norm"
+)
+
+// 单个路径段的最大字节数, 与常见文件系统一致
+const maxSegmentBytes = 255
+
+// Windows 上不能作为文件名的设备名, 带扩展名时同样保留
+var reservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true, "COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true, "LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// SanitizePath 把客户端提供的相对路径规范为只用于展示的元数据:
+// 统一为 NFC 和 '/' 分隔, 替换控制字符和各平台保留的字符, 避开保留的设备名.
+// 跳出会话目录的路径返回错误
+func SanitizePath(p string) (string, error) {
+	if !utf8.ValidString(p) {
+		p = strings.ToValidUTF8(p, "_")
+	}
+	p = norm.NFC.String(strings.ReplaceAll(p, "\\", "/"))
+	if strings.HasPrefix(p, "/") {
+		return "", fmt.Errorf("file path %q must be relative", p)
+	}
+	segs := make([]string, 0, strings.Count(p, "/")+1)
+	for _, seg := range strings.Split(p, "/") {
+		switch seg {
+		case "", ".":
+			continue
+		case "..":
+			return "", fmt.Errorf("file path %q escapes the session", p)
+		}
+		if seg = sanitizeSegment(seg); seg != "" {
+			segs = append(segs, seg)
+		}
+	}
+	if len(segs) == 0 {
+		return "", fmt.Errorf("invalid file path %q", p)
+	}
+	return strings.Join(segs, "/"), nil
+}
+
+func sanitizeSegment(seg string) string {
+	seg = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) || strings.ContainsRune(`<>:"|?*`, r) {
+			return '_'
+		}
+		return r
+	}, seg)
+	// Windows 会忽略结尾的空格和点
+	seg = strings.TrimRight(strings.TrimSpace(seg), ".")
+	if seg == "" {
+		return ""
+	}
+	base, _, _ := strings.Cut(seg, ".")
+	if reservedNames[strings.ToUpper(base)] {
+		seg = "_" + seg
+	}
+	for len(seg) > maxSegmentBytes {
+		_, size := utf8.DecodeLastRuneInString(seg)
+		seg = seg[:len(seg)-size]
+	}
+	return seg
+}
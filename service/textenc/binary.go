@@ -0,0 +1,68 @@
+package textenc
+
+import (
+	"net/http"
+)
+
+// 检查控制字符时最多查看的字节数
+const sniffLen = 8000
+
+// 魔数足够长, 可以信任 http.DetectContentType 结果的格式.
+// BMP ("BM") 和 PostScript ("%!PS") 这类前缀在普通文本中也会出现, 不在其中
+var strongMagic = map[string]bool{
+	"image/png":                    true,
+	"image/jpeg":                   true,
+	"image/gif":                    true,
+	"image/webp":                   true,
+	"image/x-icon":                 true,
+	"application/pdf":              true,
+	"application/zip":              true,
+	"application/x-gzip":           true,
+	"application/x-rar-compressed": true,
+	"application/wasm":             true,
+	"application/ogg":              true,
+	"font/ttf":                     true,
+	"font/otf":                     true,
+	"font/woff":                    true,
+	"font/woff2":                   true,
+	"audio/wave":                   true,
+	"audio/mpeg":                   true,
+	"video/mp4":                    true,
+	"video/webm":                   true,
+	"video/avi":                    true,
+}
+
+// Sniff 判断内容是否为二进制数据, 是时返回根据内容识别的 Content-Type.
+// 先按文本检查, 不是文本时才使用 http.DetectContentType 的结果, 并且只信任魔数明确的格式
+func Sniff(data []byte) (bool, string) {
+	if isText(data) {
+		return false, ""
+	}
+	if ct := http.DetectContentType(data); strongMagic[ct] {
+		return true, ct
+	}
+	return true, "application/octet-stream"
+}
+
+// isText 判断 data 是否为文本: 识别为 UTF-16, 或者开头部分不含 NUL 和常见空白以外的 C0 控制字符.
+// 其他内容都能按 Detect 识别出的编码解码, 所以不再检查编码
+func isText(data []byte) bool {
+	if enc := Detect(data); enc.Name == UTF16LE || enc.Name == UTF16BE {
+		return true
+	}
+	for _, b := range data[:min(len(data), sniffLen)] {
+		if b < 0x20 && !textControl(b) {
+			return false
+		}
+	}
+	return true
+}
+
+// textControl 报告 b 是否为文本中常见的控制字符: 制表符, 换行, 换页和终端转义序列使用的 ESC
+func textControl(b byte) bool {
+	switch b {
+	case '\t', '\n', '\v', '\f', '\r', 0x1b:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,210 @@
+package textenc
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"golang.org/x/text/encoding"
+	"golang.org/x/text/encoding/charmap"
+	"golang.org/x/text/encoding/japanese"
+	"golang.org/x/text/encoding/korean"
+	"golang.org/x/text/encoding/simplifiedchinese"
+	"golang.org/x/text/encoding/traditionalchinese"
+	"golang.org/x/text/encoding/unicode"
+)
+
+const (
+	UTF8        = "utf-8"
+	UTF16LE     = "utf-16le"
+	UTF16BE     = "utf-16be"
+	GBK         = "gbk"
+	GB18030     = "gb18030"
+	Big5        = "big5"
+	ShiftJIS    = "shift_jis"
+	EUCJP       = "euc-jp"
+	EUCKR       = "euc-kr"
+	Windows1252 = "windows-1252"
+)
+
+var (
+	bomUTF8    = []byte{0xEF, 0xBB, 0xBF}
+	bomUTF16LE = []byte{0xFF, 0xFE}
+	bomUTF16BE = []byte{0xFE, 0xFF}
+)
+
+// Encoding 描述文件原始的字符编码
+type Encoding struct {
+	Name string `json:"name"`
+	BOM  bool   `json:"bom"`
+}
+
+var Default = Encoding{Name: UTF8}
+
+var codecs = map[string]encoding.Encoding{
+	UTF16LE:     unicode.UTF16(unicode.LittleEndian, unicode.IgnoreBOM),
+	UTF16BE:     unicode.UTF16(unicode.BigEndian, unicode.IgnoreBOM),
+	GBK:         simplifiedchinese.GBK,
+	GB18030:     simplifiedchinese.GB18030,
+	Big5:        traditionalchinese.Big5,
+	ShiftJIS:    japanese.ShiftJIS,
+	EUCJP:       japanese.EUCJP,
+	EUCKR:       korean.EUCKR,
+	Windows1252: charmap.Windows1252,
+}
+
+var aliases = map[string]string{
+	"utf8": UTF8, "utf-16": UTF16LE, "utf16le": UTF16LE, "utf16be": UTF16BE,
+	"gb2312": GBK, "cp936": GBK, "sjis": ShiftJIS, "shift-jis": ShiftJIS, "cp932": ShiftJIS,
+	"eucjp": EUCJP, "euckr": EUCKR, "latin1": Windows1252, "iso-8859-1": Windows1252, "cp1252": Windows1252,
+}
+
+// ParseName 把用户提供的编码名称转换为规范名称
+func ParseName(name string) (string, error) {
+	name = strings.ToLower(strings.TrimSpace(name))
+	if alias, ok := aliases[name]; ok {
+		name = alias
+	}
+	if _, ok := codecs[name]; ok || name == UTF8 {
+		return name, nil
+	}
+	return "", fmt.Errorf("unsupported encoding %q", name)
+}
+
+func (e Encoding) bom() []byte {
+	if !e.BOM {
+		return nil
+	}
+	switch e.Name {
+	case UTF8:
+		return bomUTF8
+	case UTF16LE:
+		return bomUTF16LE
+	case UTF16BE:
+		return bomUTF16BE
+	}
+	return nil
+}
+
+// IsUTF8 表示内容可以不经转换直接作为 UTF-8 文本使用
+func (e Encoding) IsUTF8() bool {
+	return e.Name == "" || e.Name == UTF8
+}
+
+// Detect 根据 BOM 和内容推断编码, 无法确定时按 UTF-8 处理
+func Detect(data []byte) Encoding {
+	switch {
+	case bytes.HasPrefix(data, bomUTF8):
+		return Encoding{Name: UTF8, BOM: true}
+	case bytes.HasPrefix(data, bomUTF16LE):
+		return Encoding{Name: UTF16LE, BOM: true}
+	case bytes.HasPrefix(data, bomUTF16BE):
+		return Encoding{Name: UTF16BE, BOM: true}
+	}
+	if name := detectUTF16(data); name != "" {
+		return Encoding{Name: name}
+	}
+	if utf8.Valid(data) {
+		return Default
+	}
+	// 日文几乎总会包含假名, 而按 Shift-JIS 解码 GBK 内容会得到大量半角片假名
+	if text, ok := decodeClean(data, ShiftJIS); ok && kanaScore(text) > 0 {
+		return Encoding{Name: ShiftJIS}
+	}
+	for _, name := range []string{GBK, ShiftJIS, Big5, EUCKR} {
+		if _, ok := decodeClean(data, name); ok {
+			return Encoding{Name: name}
+		}
+	}
+	return Encoding{Name: Windows1252}
+}
+
+// detectUTF16 通过奇偶位置上的零字节判断没有 BOM 的 UTF-16 文本
+func detectUTF16(data []byte) string {
+	if len(data) < 4 || len(data)%2 != 0 {
+		return ""
+	}
+	sample := data[:min(len(data), 4096)]
+	var even, odd int
+	for i, b := range sample {
+		if b != 0 {
+			continue
+		}
+		if i%2 == 0 {
+			even++
+		} else {
+			odd++
+		}
+	}
+	half := len(sample) / 2
+	switch {
+	case odd > half*3/10 && even == 0:
+		return UTF16LE
+	case even > half*3/10 && odd == 0:
+		return UTF16BE
+	}
+	return ""
+}
+
+func decodeClean(data []byte, name string) (string, bool) {
+	out, err := codecs[name].NewDecoder().Bytes(data)
+	if err != nil || bytes.ContainsRune(out, utf8.RuneError) {
+		return "", false
+	}
+	return string(out), true
+}
+
+func kanaScore(text string) int {
+	score := 0
+	for _, r := range text {
+		switch {
+		case r >= 0x3040 && r <= 0x30FF:
+			score++
+		case r >= 0xFF61 && r <= 0xFF9F:
+			score--
+		}
+	}
+	return score
+}
+
+// Decode 把 e 编码的内容转换为 UTF-8 文本, 会去掉 BOM
+func Decode(data []byte, e Encoding) (string, error) {
+	if b := e.bom(); b != nil {
+		data = bytes.TrimPrefix(data, b)
+	}
+	if e.IsUTF8() {
+		return string(data), nil
+	}
+	codec, ok := codecs[e.Name]
+	if !ok {
+		return "", fmt.Errorf("unsupported encoding %q", e.Name)
+	}
+	out, err := codec.NewDecoder().Bytes(data)
+	if err != nil {
+		return "", fmt.Errorf("failed to decode %s content: %w", e.Name, err)
+	}
+	return string(out), nil
+}
+
+// Encode 把 UTF-8 文本转换为 e 编码, 文本中有无法表示的字符时返回错误
+func Encode(text string, e Encoding) ([]byte, error) {
+	var body []byte
+	if e.IsUTF8() {
+		body = []byte(text)
+	} else {
+		codec, ok := codecs[e.Name]
+		if !ok {
+			return nil, fmt.Errorf("unsupported encoding %q", e.Name)
+		}
+		out, err := codec.NewEncoder().Bytes([]byte(text))
+		if err != nil {
+			return nil, fmt.Errorf("content cannot be represented in %s: %w", e.Name, err)
+		}
+		body = out
+	}
+	if b := e.bom(); b != nil {
+		return append(append([]byte{}, b...), body...), nil
+	}
+	return body, nil
+}
@@ -0,0 +1,145 @@
+package textenc
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func mustEncode(t *testing.T, text, name string) []byte {
+	t.Helper()
+	data, err := Encode(text, Encoding{Name: name})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestDetect(t *testing.T) {
+	tests := []struct {
+		name string
+		data []byte
+		want Encoding
+	}{
+		{"empty", nil, Encoding{Name: UTF8}},
+		{"ascii", []byte("hello\n"), Encoding{Name: UTF8}},
+		{"utf-8", []byte("héllo wörld 你好\n"), Encoding{Name: UTF8}},
+		{"utf-8 bom", append([]byte{0xEF, 0xBB, 0xBF}, "x"...), Encoding{Name: UTF8, BOM: true}},
+		{"utf-16le bom", []byte{0xFF, 0xFE, 'x', 0}, Encoding{Name: UTF16LE, BOM: true}},
+		{"utf-16be bom", []byte{0xFE, 0xFF, 0, 'x'}, Encoding{Name: UTF16BE, BOM: true}},
+		{"utf-16le without bom", mustEncode(t, "hello world\n", UTF16LE), Encoding{Name: UTF16LE}},
+		{"utf-16be without bom", mustEncode(t, "hello world\n", UTF16BE), Encoding{Name: UTF16BE}},
+		{"odd length is not utf-16", []byte{'a', 0, 'b', 0, 'c'}, Encoding{Name: UTF8}},
+		{"gbk", mustEncode(t, "你好，世界。这是一个测试文件。\n", GBK), Encoding{Name: GBK}},
+		{"shift_jis", mustEncode(t, "こんにちは、世界。テストです。\n", ShiftJIS), Encoding{Name: ShiftJIS}},
+		{"windows-1252", mustEncode(t, "café", Windows1252), Encoding{Name: Windows1252}},
+	}
+	for _, tt := range tests {
+		if got := Detect(tt.data); got != tt.want {
+			t.Errorf("%s: Detect = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	tests := []struct {
+		enc  Encoding
+		text string
+	}{
+		{Encoding{Name: UTF8}, "héllo\n"},
+		{Encoding{Name: UTF8, BOM: true}, "héllo\n"},
+		{Encoding{Name: UTF16LE, BOM: true}, "héllo 世界\n"},
+		{Encoding{Name: UTF16BE}, "héllo 世界\n"},
+		{Encoding{Name: GBK}, "你好\n"},
+		{Encoding{Name: ShiftJIS}, "こんにちは\n"},
+		{Encoding{Name: Windows1252}, "café\n"},
+	}
+	for _, tt := range tests {
+		data, err := Encode(tt.text, tt.enc)
+		if err != nil {
+			t.Fatalf("%+v: Encode: %v", tt.enc, err)
+		}
+		if hasBOM := tt.enc.bom() != nil && bytes.HasPrefix(data, tt.enc.bom()); hasBOM != tt.enc.BOM {
+			t.Errorf("%+v: BOM written = %v", tt.enc, hasBOM)
+		}
+		got, err := Decode(data, tt.enc)
+		if err != nil {
+			t.Fatalf("%+v: Decode: %v", tt.enc, err)
+		}
+		if got != tt.text {
+			t.Errorf("%+v: round trip = %q, want %q", tt.enc, got, tt.text)
+		}
+	}
+}
+
+func TestEncodeUnrepresentable(t *testing.T) {
+	if _, err := Encode("日本", Encoding{Name: Windows1252}); err == nil || !strings.Contains(err.Error(), "cannot be represented") {
+		t.Fatalf("err = %v, want cannot be represented", err)
+	}
+	if _, err := Encode("x", Encoding{Name: "ebcdic"}); err == nil {
+		t.Fatal("Encode accepted an unsupported encoding")
+	}
+}
+
+func TestParseName(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"UTF-8", UTF8},
+		{" utf8 ", UTF8},
+		{"GB2312", GBK},
+		{"cp932", ShiftJIS},
+		{"Latin1", Windows1252},
+		{"utf-16", UTF16LE},
+		{"euc-kr", EUCKR},
+	}
+	for _, tt := range tests {
+		got, err := ParseName(tt.in)
+		if err != nil || got != tt.want {
+			t.Errorf("ParseName(%q) = %q, %v, want %q", tt.in, got, err, tt.want)
+		}
+	}
+	if _, err := ParseName("ebcdic"); err == nil {
+		t.Error("ParseName accepted an unsupported encoding")
+	}
+}
+
+func TestSniff(t *testing.T) {
+	png := append([]byte("\x89PNG\r\n\x1a\n\x00\x00\x00\rIHDR"), make([]byte, 16)...)
+	gz := []byte{0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x00, 0x03}
+	pdf := []byte("%PDF-1.7\n%\xe2\xe3\xcf\xd3\n1 0 obj\n<< /Length 4 >>\nstream\n\x00\x01\x02\x03\nendstream\n")
+	tests := []struct {
+		name   string
+		data   []byte
+		binary bool
+		ct     string
+	}{
+		{"empty", nil, false, ""},
+		{"text", []byte("hello\tworld\r\n"), false, ""},
+		{"ansi escapes", []byte("\x1b[31mred\x1b[0m\n"), false, ""},
+		{"form feed", []byte("page 1\fpage 2\n"), false, ""},
+		{"bmp-looking text", []byte("BMW service notes\n"), false, ""},
+		{"postscript-looking text", []byte("%!PS is how the file starts\n"), false, ""},
+		{"pdf-looking text", []byte("%PDF files are documents\n"), false, ""},
+		{"utf-16 text", mustEncode(t, "hello world\n", UTF16LE), false, ""},
+		{"gbk text", mustEncode(t, "你好，世界\n", GBK), false, ""},
+		{"png", png, true, "image/png"},
+		{"gzip", gz, true, "application/x-gzip"},
+		{"pdf", pdf, true, "application/pdf"},
+		{"unknown binary", []byte{0x00, 0x01, 0x02, 0x03, 0xff}, true, "application/octet-stream"},
+		{"bmp-looking binary", []byte("BM6\x00\x0c\x00\x00\x00\x00\x006\x00\x00\x00(\x00"), true, "application/octet-stream"},
+	}
+	for _, tt := range tests {
+		binary, ct := Sniff(tt.data)
+		if binary != tt.binary || ct != tt.ct {
+			t.Errorf("%s: Sniff = %v, %q, want %v, %q", tt.name, binary, ct, tt.binary, tt.ct)
+		}
+	}
+}
+
+func TestSniffLooksOnlyAtPrefix(t *testing.T) {
+	data := append(bytes.Repeat([]byte("a"), sniffLen), 0x00)
+	if binary, _ := Sniff(data); binary {
+		t.Fatal("a control character past sniffLen made the content binary")
+	}
+}
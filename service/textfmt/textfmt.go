@@ -0,0 +1,187 @@
+package textfmt
+
+import (
+	"fmt"
+	"strings"
+)
+
+const (
+	LF   = "lf"
+	CRLF = "crlf"
+	CR   = "cr"
+
+	IndentTab   = "tab"
+	IndentSpace = "space"
+	IndentMixed = "mixed"
+)
+
+// 某种缩进至少占有缩进行的这个比例才认为文件使用该风格
+const dominantRatio = 0.9
+
+// Format 描述文本的换行符, 结尾换行和缩进风格
+type Format struct {
+	LineEnding   string `json:"line_ending"`
+	FinalNewline bool   `json:"final_newline"`
+	// Indent 为空表示文件没有缩进行
+	Indent     string `json:"indent,omitempty"`
+	IndentSize int    `json:"indent_size,omitempty"`
+	// TrimTrailingWhitespace 不是从内容中识别的, 只由 EditorConfig 等规则设置
+	TrimTrailingWhitespace bool `json:"trim_trailing_whitespace,omitempty"`
+	// ConvertIndent 表示 Indent 是保存请求或 EditorConfig 显式指定的, 只有这时 Normalize 才转换缩进
+	ConvertIndent bool `json:"-"`
+}
+
+var Default = Format{LineEnding: LF, FinalNewline: true}
+
+func ParseLineEnding(s string) (string, error) {
+	switch s = strings.ToLower(s); s {
+	case LF, CRLF, CR:
+		return s, nil
+	}
+	return "", fmt.Errorf("unsupported line ending %q", s)
+}
+
+func ParseIndent(s string) (string, error) {
+	switch s = strings.ToLower(s); s {
+	case IndentTab, IndentSpace, IndentMixed:
+		return s, nil
+	}
+	return "", fmt.Errorf("unsupported indent style %q", s)
+}
+
+func (f Format) newline() string {
+	switch f.LineEnding {
+	case CRLF:
+		return "\r\n"
+	case CR:
+		return "\r"
+	}
+	return "\n"
+}
+
+// Analyze 统计文本中的换行符和缩进, 混合使用时取占多数的风格
+func Analyze(text string) Format {
+	f := Format{LineEnding: LF}
+	var lf, crlf, cr int
+	for i := 0; i < len(text); i++ {
+		switch text[i] {
+		case '\n':
+			lf++
+		case '\r':
+			if i+1 < len(text) && text[i+1] == '\n' {
+				crlf++
+				i++
+			} else {
+				cr++
+			}
+		}
+	}
+	switch {
+	case crlf > lf && crlf >= cr:
+		f.LineEnding = CRLF
+	case cr > lf && cr > crlf:
+		f.LineEnding = CR
+	}
+	f.FinalNewline = strings.HasSuffix(text, "\n") || strings.HasSuffix(text, "\r")
+	f.Indent, f.IndentSize = analyzeIndent(splitLines(text))
+	return f
+}
+
+func splitLines(text string) []string {
+	return strings.Split(strings.ReplaceAll(strings.ReplaceAll(text, "\r\n", "\n"), "\r", "\n"), "\n")
+}
+
+// analyzeIndent 根据以 tab 和空格开头的行数判断缩进风格,
+// 空格缩进的宽度取相邻缩进行之间最常见的增量
+func analyzeIndent(lines []string) (string, int) {
+	var tabs, spaces int
+	deltas := make(map[int]int)
+	prev := 0
+	for _, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		switch line[0] {
+		case '\t':
+			tabs++
+		case ' ':
+			spaces++
+		}
+		width := len(line) - len(strings.TrimLeft(line, " "))
+		if d := width - prev; d > 1 && d <= 8 {
+			deltas[d]++
+		}
+		prev = width
+	}
+	total := tabs + spaces
+	switch {
+	case total == 0:
+		return "", 0
+	case float64(tabs) >= float64(total)*dominantRatio:
+		return IndentTab, 0
+	case float64(spaces) < float64(total)*dominantRatio:
+		return IndentMixed, 0
+	}
+	size, best := 0, 0
+	for _, d := range []int{2, 4, 8, 3, 5, 6, 7} {
+		if deltas[d] > best {
+			size, best = d, deltas[d]
+		}
+	}
+	if size == 0 {
+		size = 4
+	}
+	return IndentSpace, size
+}
+
+// Normalize 把浏览器提交的文本转换为 f 描述的换行符和结尾换行.
+// 转换缩进会改写用户没有修改的行, 所以只在 f.ConvertIndent 时进行, 并且只转换行首由另一种字符组成的部分,
+// mixed 和未知的缩进保持不变
+func Normalize(text string, f Format) string {
+	lines := splitLines(text)
+	// 去掉一个结尾换行, 需要时在最后按 f 补回
+	if len(lines) > 1 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+	size := f.IndentSize
+	if size <= 0 {
+		size = 4
+	}
+	for i, line := range lines {
+		if f.TrimTrailingWhitespace {
+			line = strings.TrimRight(line, " \t")
+		}
+		switch {
+		case f.ConvertIndent && f.Indent == IndentTab:
+			lines[i] = spacesToTabs(line, size)
+		case f.ConvertIndent && f.Indent == IndentSpace:
+			lines[i] = tabsToSpaces(line, size)
+		default:
+			lines[i] = line
+		}
+	}
+	nl := f.newline()
+	out := strings.Join(lines, nl)
+	// 只含一个换行的文件去掉结尾换行后 out 为空, 按 text 判断才不会被清空
+	if f.FinalNewline && text != "" {
+		out += nl
+	}
+	return out
+}
+
+func spacesToTabs(line string, size int) string {
+	indent := len(line) - len(strings.TrimLeft(line, " "))
+	if indent < size {
+		return line
+	}
+	n := indent / size
+	return strings.Repeat("\t", n) + line[n*size:]
+}
+
+func tabsToSpaces(line string, size int) string {
+	indent := len(line) - len(strings.TrimLeft(line, "\t"))
+	if indent == 0 {
+		return line
+	}
+	return strings.Repeat(" ", indent*size) + line[indent:]
+}
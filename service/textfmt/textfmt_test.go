@@ -0,0 +1,93 @@
+package textfmt
+
+import "testing"
+
+func TestAnalyze(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		want Format
+	}{
+		{"empty", "", Format{LineEnding: LF}},
+		{"lf", "a\nb\n", Format{LineEnding: LF, FinalNewline: true}},
+		{"crlf", "a\r\nb\r\n", Format{LineEnding: CRLF, FinalNewline: true}},
+		{"cr", "a\rb\r", Format{LineEnding: CR, FinalNewline: true}},
+		{"no final newline", "a\nb", Format{LineEnding: LF}},
+		{"majority wins", "a\r\nb\r\nc\nd\r\n", Format{LineEnding: CRLF, FinalNewline: true}},
+		{"tie prefers lf", "a\r\nb\n", Format{LineEnding: LF, FinalNewline: true}},
+		{"tabs", "f {\n\tx\n\t\ty\n}\n", Format{LineEnding: LF, FinalNewline: true, Indent: IndentTab}},
+		{"two spaces", "a:\n  b:\n    c\n  d\n", Format{LineEnding: LF, FinalNewline: true, Indent: IndentSpace, IndentSize: 2}},
+		{"four spaces", "def f():\n    if x:\n        y\n    z\n", Format{LineEnding: LF, FinalNewline: true, Indent: IndentSpace, IndentSize: 4}},
+		{"mixed", "a\n\tb\n  c\n\td\n  e\n", Format{LineEnding: LF, FinalNewline: true, Indent: IndentMixed}},
+		{"blank lines are ignored", "a\n\n   \n\tb\n", Format{LineEnding: LF, FinalNewline: true, Indent: IndentTab}},
+	}
+	for _, tt := range tests {
+		if got := Analyze(tt.text); got != tt.want {
+			t.Errorf("%s: Analyze = %+v, want %+v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		name string
+		text string
+		f    Format
+		want string
+	}{
+		{"empty stays empty", "", Format{LineEnding: LF, FinalNewline: true}, ""},
+		{"single newline kept", "\n", Format{LineEnding: CRLF, FinalNewline: true}, "\r\n"},
+		{"single newline removed", "\n", Format{LineEnding: LF}, ""},
+		{"to crlf", "a\nb\r\nc\rd", Format{LineEnding: CRLF, FinalNewline: true}, "a\r\nb\r\nc\r\nd\r\n"},
+		{"to cr", "a\nb\n", Format{LineEnding: CR, FinalNewline: true}, "a\rb\r"},
+		{"add final newline", "a\nb", Format{LineEnding: LF, FinalNewline: true}, "a\nb\n"},
+		{"drop final newline", "a\nb\n", Format{LineEnding: LF}, "a\nb"},
+		{"only one final newline is dropped", "a\n\n", Format{LineEnding: LF}, "a\n"},
+		{"blank last line kept", "a\n\n", Format{LineEnding: LF, FinalNewline: true}, "a\n\n"},
+		{"trim trailing whitespace", "a \t\n b  \n", Format{LineEnding: LF, FinalNewline: true, TrimTrailingWhitespace: true}, "a\n b\n"},
+		{
+			"detected indent is not converted", "\tx\n    y\n",
+			Format{LineEnding: LF, FinalNewline: true, Indent: IndentSpace, IndentSize: 4}, "\tx\n    y\n",
+		},
+		{
+			"spaces to tabs", "    x\n      y\n  z\n",
+			Format{LineEnding: LF, FinalNewline: true, Indent: IndentTab, IndentSize: 4, ConvertIndent: true}, "\tx\n\t  y\n  z\n",
+		},
+		{
+			"tabs to spaces", "\tx\n\t\ty\n\t z\n",
+			Format{LineEnding: LF, FinalNewline: true, Indent: IndentSpace, IndentSize: 2, ConvertIndent: true}, "  x\n    y\n   z\n",
+		},
+		{
+			"default indent size", "\tx\n",
+			Format{LineEnding: LF, FinalNewline: true, Indent: IndentSpace, ConvertIndent: true}, "    x\n",
+		},
+		{
+			"mixed is left alone", "\tx\n  y\n",
+			Format{LineEnding: LF, FinalNewline: true, Indent: IndentMixed, ConvertIndent: true}, "\tx\n  y\n",
+		},
+		{
+			"only leading indentation is converted", "\ta\tb\n",
+			Format{LineEnding: LF, FinalNewline: true, Indent: IndentSpace, IndentSize: 4, ConvertIndent: true}, "    a\tb\n",
+		},
+	}
+	for _, tt := range tests {
+		if got := Normalize(tt.text, tt.f); got != tt.want {
+			t.Errorf("%s: Normalize = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestParse(t *testing.T) {
+	if le, err := ParseLineEnding("CRLF"); err != nil || le != CRLF {
+		t.Errorf("ParseLineEnding(CRLF) = %q, %v", le, err)
+	}
+	if _, err := ParseLineEnding("nel"); err == nil {
+		t.Error("ParseLineEnding accepted nel")
+	}
+	if indent, err := ParseIndent("Tab"); err != nil || indent != IndentTab {
+		t.Errorf("ParseIndent(Tab) = %q, %v", indent, err)
+	}
+	if _, err := ParseIndent("smart"); err == nil {
+		t.Error("ParseIndent accepted smart")
+	}
+}
@@ -0,0 +1,257 @@
+package webhook
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"remdit-server/config"
+	"remdit-server/service/events"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+const (
+	defaultMaxRetries = 5
+	queueSize         = 1024
+	workers           = 4
+	historySize       = 200
+	attemptTimeout    = 10 * time.Second
+	maxBackoff        = 5 * time.Minute
+)
+
+type Status string
+
+const (
+	StatusPending   Status = "pending"
+	StatusDelivered Status = "delivered"
+	StatusFailed    Status = "failed"
+	StatusDropped   Status = "dropped"
+)
+
+// Delivery 记录一次 webhook 投递的状态
+type Delivery struct {
+	ID         string      `json:"id"`
+	Webhook    string      `json:"webhook"`
+	Event      events.Type `json:"event"`
+	EventID    uint64      `json:"event_id"`
+	SessionID  string      `json:"sessionid"`
+	Status     Status      `json:"status"`
+	Attempts   int         `json:"attempts"`
+	StatusCode int         `json:"status_code,omitempty"`
+	LastError  string      `json:"last_error,omitempty"`
+	CreatedAt  time.Time   `json:"created_at"`
+	UpdatedAt  time.Time   `json:"updated_at"`
+}
+
+type job struct {
+	hook     config.Webhook
+	event    events.Event
+	body     []byte
+	delivery *Delivery
+	attempt  int // 从 1 开始的本次尝试次数
+	backoff  time.Duration
+}
+
+// Dispatcher 订阅事件总线并异步投递 webhook, 不会阻塞事件发布方
+type Dispatcher struct {
+	client *http.Client
+	queue  chan job
+
+	mu      sync.Mutex
+	history []*Delivery
+}
+
+var defaultDispatcher = NewDispatcher()
+
+func Default() *Dispatcher {
+	return defaultDispatcher
+}
+
+func NewDispatcher() *Dispatcher {
+	return &Dispatcher{
+		client: &http.Client{Timeout: attemptTimeout},
+		queue:  make(chan job, queueSize),
+	}
+}
+
+// Start 启动事件订阅和投递 worker, 直到 ctx 结束
+func (d *Dispatcher) Start(ctx context.Context) {
+	sub := events.Subscribe(events.Filter{}, queueSize)
+	for range workers {
+		go d.work(ctx)
+	}
+	go func() {
+		defer sub.Close()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case e, ok := <-sub.C():
+				if !ok {
+					return
+				}
+				d.dispatch(e)
+			}
+		}
+	}()
+}
+
+func (d *Dispatcher) dispatch(e events.Event) {
+	hooks := config.C().Webhooks
+	if len(hooks) == 0 {
+		return
+	}
+	body, err := json.Marshal(e)
+	if err != nil {
+		slog.Error("Failed to marshal webhook payload", "event", e.Type, "err", err)
+		return
+	}
+	for _, hook := range hooks {
+		if len(hook.Events) > 0 && !slices.Contains(hook.Events, string(e.Type)) {
+			continue
+		}
+		now := time.Now()
+		delivery := &Delivery{
+			ID:        uuid.NewString(),
+			Webhook:   hook.Name,
+			Event:     e.Type,
+			EventID:   e.ID,
+			SessionID: e.SessionID,
+			Status:    StatusPending,
+			CreatedAt: now,
+			UpdatedAt: now,
+		}
+		d.record(delivery)
+		select {
+		case d.queue <- job{hook: hook, event: e, body: body, delivery: delivery, attempt: 1, backoff: time.Second}:
+		default:
+			d.update(delivery, func(dl *Delivery) {
+				dl.Status = StatusDropped
+				dl.LastError = "delivery queue full"
+			})
+			slog.Warn("Webhook queue full, dropping delivery", "webhook", hook.Name, "event", e.Type)
+		}
+	}
+}
+
+func (d *Dispatcher) work(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case j := <-d.queue:
+			d.deliver(ctx, j)
+		}
+	}
+}
+
+// deliver 进行一次投递尝试. 失败后由定时器在退避时间后重新入队, 等待期间不占用 worker,
+// 一个失效的接收方不会阻塞其他 webhook 的投递
+func (d *Dispatcher) deliver(ctx context.Context, j job) {
+	retries := defaultMaxRetries
+	if j.hook.MaxRetries != nil {
+		retries = *j.hook.MaxRetries
+	}
+	code, err := d.post(ctx, j)
+	d.update(j.delivery, func(dl *Delivery) {
+		dl.Attempts = j.attempt
+		dl.StatusCode = code
+		dl.LastError = ""
+		if err != nil {
+			dl.LastError = err.Error()
+		}
+	})
+	if err == nil {
+		d.update(j.delivery, func(dl *Delivery) { dl.Status = StatusDelivered })
+		slog.Debug("Webhook delivered", "webhook", j.hook.Name, "event", j.event.Type, "attempts", j.attempt)
+		return
+	}
+	slog.Warn("Webhook delivery failed", "webhook", j.hook.Name, "event", j.event.Type, "attempt", j.attempt, "err", err)
+	if j.attempt > retries {
+		d.update(j.delivery, func(dl *Delivery) { dl.Status = StatusFailed })
+		slog.Error("Webhook delivery gave up", "webhook", j.hook.Name, "event", j.event.Type, "delivery", j.delivery.ID)
+		return
+	}
+	next := j
+	next.attempt++
+	next.backoff = min(j.backoff*2, maxBackoff)
+	time.AfterFunc(j.backoff, func() {
+		select {
+		case <-ctx.Done():
+		case d.queue <- next:
+		}
+	})
+}
+
+func (d *Dispatcher) post(ctx context.Context, j job) (int, error) {
+	ctx, cancel := context.WithTimeout(ctx, attemptTimeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, j.hook.URL, bytes.NewReader(j.body))
+	if err != nil {
+		return 0, err
+	}
+	timestamp := strconv.FormatInt(time.Now().Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("User-Agent", "remdit-server")
+	req.Header.Set("X-Remdit-Event", string(j.event.Type))
+	req.Header.Set("X-Remdit-Delivery", j.delivery.ID)
+	req.Header.Set("X-Remdit-Timestamp", timestamp)
+	req.Header.Set("X-Remdit-Signature", "sha256="+Sign(j.hook.Secret, timestamp, j.body))
+	resp, err := d.client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// Sign 计算 "<timestamp>.<body>" 的 HMAC-SHA256 签名
+func Sign(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte("."))
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+func (d *Dispatcher) record(dl *Delivery) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.history = append(d.history, dl)
+	if len(d.history) > historySize {
+		d.history = d.history[len(d.history)-historySize:]
+	}
+}
+
+func (d *Dispatcher) update(dl *Delivery, fn func(*Delivery)) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	fn(dl)
+	dl.UpdatedAt = time.Now()
+}
+
+// Deliveries 返回最近的投递记录, 最新的在前
+func (d *Dispatcher) Deliveries() []Delivery {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	result := make([]Delivery, 0, len(d.history))
+	for i := len(d.history) - 1; i >= 0; i-- {
+		result = append(result, *d.history[i])
+	}
+	return result
+}